@@ -0,0 +1,976 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Grouped queries are sent to immudb when it is able to run them: it groups by a single indexed column, ordering
+// the groups by it, and computes COUNT(*), SUM, MIN, MAX and AVG of integer columns. Other grouped queries are
+// aggregated on the client over the streamed rows of the query without grouping, then HAVING, ORDER BY and LIMIT
+// are applied to the groups. Both name the aggregates without an alias after their position, like col1.
+
+var (
+	aggregateRegexp = regexp.MustCompile(`(?i)^(count|sum|min|max|avg)\s*\(\s*(\*|[a-z_]\w*(?:\.[a-z_]\w*)?)\s*\)$`)
+	columnRegexp    = regexp.MustCompile(`(?i)^[a-z_]\w*(?:\.[a-z_]\w*)?$`)
+	aliasRegexp     = regexp.MustCompile(`(?i)^(.*?)(?:\s+as)?\s+([a-z_]\w*)$`)
+	orderRegexp     = regexp.MustCompile(`(?i)^(.*?)\s+(asc|desc)$`)
+)
+
+// aggregate is an aggregate function of a column of the rows of a group, or of the rows for count(*)
+type aggregate struct {
+	fn  string
+	arg string
+}
+
+// operand is a value of a group: a grouped column, an aggregate, a selected column or a literal value
+type operand struct {
+	column string
+	agg    *aggregate
+	value  interface{}
+}
+
+type selectItem struct {
+	name string
+	expr operand
+}
+
+type orderKey struct {
+	expr operand
+	desc bool
+}
+
+// aggregation is a grouped query aggregated on the client
+type aggregation struct {
+	groupBy    []string
+	items      []selectItem
+	having     condition
+	orderBy    []orderKey
+	limit      clause.Limit
+	aggregates []aggregate
+}
+
+// groupBy sends the grouped queries immudb is able to run, adding the order on the grouped column it requires,
+// and aggregates the others on the client
+func (dialector *Dialector) groupBy(db *gorm.DB) {
+	stmt := db.Statement
+	c, ok := stmt.Clauses["GROUP BY"]
+	groupBy, isGroupBy := c.Expression.(clause.GroupBy)
+	if db.Error != nil || !ok || !isGroupBy || len(groupBy.Columns) == 0 || stmt.SQL.Len() > 0 {
+		return
+	}
+	// the groups are not stored rows, no proof exists for them
+	if dialector.verifyEnabled() {
+		db.AddError(ErrGroupByNotVerifiable)
+		return
+	}
+
+	a, err := parseAggregation(stmt, groupBy)
+	if err == nil {
+		if column, ok := a.encryptedColumn(stmt.Schema); ok {
+			db.AddError(fmt.Errorf("%w: %s is encrypted", ErrUnsupportedAggregation, column))
+			return
+		}
+	}
+	if (err == nil && a.runsOnServer(stmt.Schema)) || (err != nil && groupsOnServer(stmt.Schema, groupBy.Columns)) {
+		if _, ok := stmt.Clauses["ORDER BY"]; !ok {
+			stmt.AddClause(clause.OrderBy{Columns: []clause.OrderByColumn{{Column: groupBy.Columns[0]}}})
+		}
+		return
+	}
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if onServerOnly(db, "the aggregation") {
+		return
+	}
+
+	var rows *resultRows
+	if !db.DryRun {
+		if rows, err = a.run(db); err != nil {
+			db.AddError(err)
+			return
+		}
+	}
+	callbacks.BuildQuerySQL(db)
+	if rows != nil {
+		stmt.ConnPool = &resultConnPool{ConnPool: stmt.ConnPool, sql: stmt.SQL.String(), rows: rows}
+	}
+}
+
+// groupsOnServer reports whether immudb is able to group by the columns: a single indexed column
+func groupsOnServer(s *schema.Schema, columns []clause.Column) bool {
+	if s == nil || len(columns) != 1 || columns[0].Raw {
+		return false
+	}
+	field := s.LookUpField(columns[0].Name)
+	if field == nil || isFloatField(field) {
+		return false
+	}
+	if field.Unique || (field.PrimaryKey && len(s.PrimaryFields) == 1) {
+		return true
+	}
+	for _, idx := range s.ParseIndexes() {
+		if len(idx.Fields) == 1 && idx.Fields[0].Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptedColumn returns a grouped or aggregated column holding ciphertext, which cannot be compared like the values.
+// Counting only tells NULL values apart, so it is allowed.
+func (a *aggregation) encryptedColumn(s *schema.Schema) (string, bool) {
+	for _, name := range a.groupBy {
+		if isEncryptedColumn(s, unqualified(name)) {
+			return name, true
+		}
+	}
+	for _, agg := range a.aggregates {
+		if agg.fn != "count" && isEncryptedColumn(s, unqualified(agg.arg)) {
+			return agg.arg, true
+		}
+	}
+	return "", false
+}
+
+func isFloatField(field *schema.Field) bool {
+	kind := field.IndirectFieldType.Kind()
+	return field.Serializer == nil && (kind == reflect.Float32 || kind == reflect.Float64)
+}
+
+// runsOnServer reports whether immudb is able to run the grouped query
+func (a *aggregation) runsOnServer(s *schema.Schema) bool {
+	columns := make([]clause.Column, len(a.groupBy))
+	for i, name := range a.groupBy {
+		columns[i] = clause.Column{Name: unqualified(name)}
+	}
+	if !groupsOnServer(s, columns) {
+		return false
+	}
+	if len(a.orderBy) > 1 || (len(a.orderBy) == 1 && a.orderBy[0].expr.column != unqualified(a.groupBy[0])) {
+		return false
+	}
+
+	supported := func(o operand) bool {
+		switch {
+		case o.agg != nil && o.agg.fn == "count":
+			return o.agg.arg == "*"
+		case o.agg != nil:
+			field := s.LookUpField(unqualified(o.agg.arg))
+			return field != nil && !isFloatField(field)
+		case o.column != "":
+			// the server does not resolve aliases
+			return o.column == unqualified(a.groupBy[0])
+		default:
+			_, isFloat := o.value.(float64)
+			return !isFloat
+		}
+	}
+	for _, item := range a.items {
+		if item.expr.agg != nil && !supported(item.expr) {
+			return false
+		}
+	}
+	return a.having == nil || a.having.all(supported)
+}
+
+func unqualified(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// splitList splits a comma separated list, leaving the commas between parentheses
+func splitList(s string) []string {
+	var (
+		items []string
+		depth int
+		start int
+	)
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(items, strings.TrimSpace(s[start:]))
+}
+
+func parseAggregation(stmt *gorm.Statement, groupBy clause.GroupBy) (*aggregation, error) {
+	a := &aggregation{}
+	for _, column := range groupBy.Columns {
+		names := []string{column.Name}
+		if column.Raw {
+			names = splitList(column.Name)
+		}
+		for _, name := range names {
+			if !columnRegexp.MatchString(name) {
+				return nil, fmt.Errorf("%w: group by %s", ErrUnsupportedAggregation, name)
+			}
+			a.groupBy = append(a.groupBy, name)
+		}
+	}
+
+	selected, err := selectedExprs(stmt)
+	if err != nil {
+		return nil, err
+	}
+	for idx, s := range selected {
+		item := selectItem{name: "col" + strconv.Itoa(idx)}
+		if m := aliasRegexp.FindStringSubmatch(s); m != nil && !strings.HasSuffix(s, ")") {
+			s, item.name = strings.TrimSpace(m[1]), strings.ToLower(m[2])
+		}
+		if item.expr, err = a.parseOperand(s); err != nil {
+			return nil, err
+		}
+		if item.expr.agg == nil {
+			if item.expr.column == "" || !a.isGrouped(item.expr.column) {
+				return nil, fmt.Errorf("%w: %s is neither grouped nor aggregated", ErrUnsupportedAggregation, s)
+			}
+			if !aliasRegexp.MatchString(selected[idx]) {
+				item.name = item.expr.column
+			}
+		}
+		a.items = append(a.items, item)
+	}
+
+	if a.having, err = a.parseHaving(groupBy.Having); err != nil {
+		return nil, err
+	}
+
+	if c, ok := stmt.Clauses["ORDER BY"]; ok {
+		orderBy, _ := c.Expression.(clause.OrderBy)
+		if orderBy.Expression != nil {
+			return nil, fmt.Errorf("%w: order by expression", ErrUnsupportedAggregation)
+		}
+		for _, column := range orderBy.Columns {
+			texts := []string{column.Column.Name}
+			if column.Column.Raw {
+				texts = splitList(column.Column.Name)
+			}
+			for _, text := range texts {
+				key := orderKey{desc: column.Desc}
+				if m := orderRegexp.FindStringSubmatch(text); m != nil {
+					text, key.desc = m[1], strings.EqualFold(m[2], "desc")
+				}
+				if key.expr, err = a.parseOperand(text); err != nil {
+					return nil, err
+				}
+				a.orderBy = append(a.orderBy, key)
+			}
+		}
+	}
+
+	if c, ok := stmt.Clauses["LIMIT"]; ok {
+		a.limit, _ = c.Expression.(clause.Limit)
+	}
+	return a, nil
+}
+
+// selectedExprs returns the selected expressions, the ones of the SELECT clause first, like gorm does
+func selectedExprs(stmt *gorm.Statement) ([]string, error) {
+	if c, ok := stmt.Clauses["SELECT"]; ok {
+		switch sel := c.Expression.(type) {
+		case clause.Expr:
+			// the expression of a select clause replaces the clause when it is added
+			if len(sel.Vars) > 0 {
+				return nil, fmt.Errorf("%w: select %s", ErrUnsupportedAggregation, sel.SQL)
+			}
+			return splitList(sel.SQL), nil
+		case clause.Select:
+			if len(sel.Columns) > 0 {
+				var selected []string
+				for _, column := range sel.Columns {
+					if column.Raw {
+						selected = append(selected, splitList(column.Name)...)
+					} else {
+						selected = append(selected, column.Name)
+					}
+				}
+				return selected, nil
+			}
+		}
+	}
+
+	var selected []string
+	for _, s := range stmt.Selects {
+		selected = append(selected, splitList(s)...)
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%w: the grouped and aggregated columns must be selected", ErrUnsupportedAggregation)
+	}
+	return selected, nil
+}
+
+func (a *aggregation) isGrouped(column string) bool {
+	for _, name := range a.groupBy {
+		if unqualified(name) == column {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOperand parses an aggregate or a column name, which is looked up among the grouped and the selected columns
+func (a *aggregation) parseOperand(s string) (operand, error) {
+	s = strings.TrimSpace(s)
+	if m := aggregateRegexp.FindStringSubmatch(s); m != nil {
+		agg := aggregate{fn: strings.ToLower(m[1]), arg: strings.ToLower(m[2])}
+		if agg.arg == "*" && agg.fn != "count" {
+			return operand{}, fmt.Errorf("%w: %s", ErrUnsupportedAggregation, s)
+		}
+		found := false
+		for _, registered := range a.aggregates {
+			found = found || registered == agg
+		}
+		if !found {
+			a.aggregates = append(a.aggregates, agg)
+		}
+		return operand{agg: &agg}, nil
+	}
+	if columnRegexp.MatchString(s) {
+		return operand{column: unqualified(s)}, nil
+	}
+	return operand{}, fmt.Errorf("%w: %s", ErrUnsupportedAggregation, s)
+}
+
+// condition is a HAVING condition
+type condition interface {
+	eval(g *group) (bool, error)
+	// all reports whether f holds for every operand of the condition
+	all(f func(operand) bool) bool
+}
+
+type comparison struct {
+	left, right operand
+	op          string
+}
+
+type logical struct {
+	or    bool
+	conds []condition
+}
+
+func (c comparison) eval(g *group) (bool, error) {
+	left, err := g.value(c.left)
+	if err != nil {
+		return false, err
+	}
+	right, err := g.value(c.right)
+	if err != nil {
+		return false, err
+	}
+	cmp, ok := compareValues(left, right)
+	if !ok || left == nil || right == nil {
+		return false, nil
+	}
+	switch c.op {
+	case "=":
+		return cmp == 0, nil
+	case "!=", "<>":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+func (c comparison) all(f func(operand) bool) bool {
+	return f(c.left) && f(c.right)
+}
+
+func (l logical) eval(g *group) (bool, error) {
+	for _, cond := range l.conds {
+		ok, err := cond.eval(g)
+		if err != nil || ok == l.or {
+			return ok, err
+		}
+	}
+	return !l.or, nil
+}
+
+func (l logical) all(f func(operand) bool) bool {
+	for _, cond := range l.conds {
+		if !cond.all(f) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *aggregation) parseHaving(exprs []clause.Expression) (condition, error) {
+	if len(exprs) == 0 {
+		return nil, nil
+	}
+
+	conds := make([]condition, 0, len(exprs))
+	for _, expr := range exprs {
+		var (
+			column interface{}
+			value  interface{}
+			op     string
+		)
+		switch v := expr.(type) {
+		case clause.Expr:
+			p := &havingParser{a: a, tokens: tokenize(v.SQL), vars: v.Vars}
+			cond, err := p.parseOr()
+			if err == nil && p.pos < len(p.tokens) {
+				err = fmt.Errorf("%w: having %s", ErrUnsupportedAggregation, v.SQL)
+			}
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, cond)
+			continue
+		case clause.Eq:
+			column, value, op = v.Column, v.Value, "="
+		case clause.Neq:
+			column, value, op = v.Column, v.Value, "!="
+		case clause.Gt:
+			column, value, op = v.Column, v.Value, ">"
+		case clause.Gte:
+			column, value, op = v.Column, v.Value, ">="
+		case clause.Lt:
+			column, value, op = v.Column, v.Value, "<"
+		case clause.Lte:
+			column, value, op = v.Column, v.Value, "<="
+		default:
+			return nil, fmt.Errorf("%w: having %T", ErrUnsupportedAggregation, expr)
+		}
+
+		name, ok := column.(string)
+		if c, isColumn := column.(clause.Column); isColumn {
+			name, ok = c.Name, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: having %v", ErrUnsupportedAggregation, column)
+		}
+		left, err := a.parseOperand(name)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, comparison{left: left, right: operand{value: normalizeValue(value)}, op: op})
+	}
+	if len(conds) == 1 {
+		return conds[0], nil
+	}
+	return logical{conds: conds}, nil
+}
+
+var tokenRegexp = regexp.MustCompile(`(?i)\s*((?:count|sum|min|max|avg)\s*\(\s*(?:\*|[a-z_][\w.]*)\s*\)|[a-z_][\w.]*|'(?:[^']|'')*'|-?\d+(?:\.\d+)?|<=|>=|<>|!=|[=<>?()])`)
+
+func tokenize(s string) []string {
+	var tokens []string
+	for _, m := range tokenRegexp.FindAllStringSubmatch(s, -1) {
+		tokens = append(tokens, m[1])
+	}
+	// characters left out make the condition unsupported
+	if len(strings.Join(tokens, "")) != len(strings.Join(strings.Fields(s), "")) {
+		return append(tokens, "")
+	}
+	return tokens
+}
+
+// havingParser parses conditions made of comparisons of aggregates, columns, values and parameters, joined by AND
+// and OR
+type havingParser struct {
+	a      *aggregation
+	tokens []string
+	pos    int
+	vars   []interface{}
+}
+
+func (p *havingParser) next() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *havingParser) parseOr() (condition, error) {
+	return p.parseLogical(true)
+}
+
+func (p *havingParser) parseLogical(or bool) (condition, error) {
+	keyword := "and"
+	parse := p.parseComparison
+	if or {
+		keyword, parse = "or", func() (condition, error) { return p.parseLogical(false) }
+	}
+
+	cond, err := parse()
+	if err != nil {
+		return nil, err
+	}
+	conds := []condition{cond}
+	for strings.EqualFold(p.next(), keyword) {
+		p.pos++
+		if cond, err = parse(); err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+	if len(conds) == 1 {
+		return conds[0], nil
+	}
+	return logical{or: or, conds: conds}, nil
+}
+
+func (p *havingParser) parseComparison() (condition, error) {
+	if p.next() == "(" {
+		p.pos++
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("%w: having %s", ErrUnsupportedAggregation, strings.Join(p.tokens, " "))
+		}
+		p.pos++
+		return cond, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	switch op {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+		p.pos++
+	default:
+		return nil, fmt.Errorf("%w: having %s", ErrUnsupportedAggregation, strings.Join(p.tokens, " "))
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return comparison{left: left, right: right, op: op}, nil
+}
+
+func (p *havingParser) parseOperand() (operand, error) {
+	token := p.next()
+	p.pos++
+	switch {
+	case token == "?":
+		if len(p.vars) == 0 {
+			return operand{}, fmt.Errorf("%w: missing parameter", ErrUnsupportedAggregation)
+		}
+		value := p.vars[0]
+		p.vars = p.vars[1:]
+		return operand{value: normalizeValue(value)}, nil
+	case strings.HasPrefix(token, "'"):
+		return operand{value: strings.ReplaceAll(token[1:len(token)-1], "''", "'")}, nil
+	case strings.EqualFold(token, "true"), strings.EqualFold(token, "false"):
+		return operand{value: strings.EqualFold(token, "true")}, nil
+	case strings.EqualFold(token, "null"):
+		return operand{}, nil
+	}
+	if i, err := strconv.ParseInt(token, 10, 64); err == nil {
+		return operand{value: i}, nil
+	}
+	if f, err := strconv.ParseFloat(token, 64); err == nil {
+		return operand{value: f}, nil
+	}
+	return p.a.parseOperand(token)
+}
+
+// normalizeValue converts a parameter to the values compared by compareValues
+func normalizeValue(v interface{}) interface{} {
+	if valuer, ok := v.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil {
+			return nil
+		}
+		v = value
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Invalid:
+		return nil
+	}
+	return rv.Interface()
+}
+
+// compareValues compares two values of the same kind, numbers of any kind are compared with each other.
+// NULL is lower than any other value.
+func compareValues(a, b interface{}) (int, bool) {
+	switch {
+	case a == nil && b == nil:
+		return 0, true
+	case a == nil:
+		return -1, true
+	case b == nil:
+		return 1, true
+	}
+
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return compareOrdered(x < y, x > y), true
+		case float64:
+			return compareOrdered(float64(x) < y, float64(x) > y), true
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return compareOrdered(x < float64(y), x > float64(y)), true
+		case float64:
+			return compareOrdered(x < y, x > y), true
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case []byte:
+		if y, ok := b.([]byte); ok {
+			return bytes.Compare(x, y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			return compareOrdered(!x && y, x && !y), true
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return compareOrdered(x.Before(y), x.After(y)), true
+		}
+	}
+	return 0, false
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+// accumulator holds the state of an aggregate of a group
+type accumulator struct {
+	count int64
+	sum   interface{}
+	min   interface{}
+	max   interface{}
+}
+
+func (acc *accumulator) add(fn string, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	acc.count++
+	switch fn {
+	case "sum", "avg":
+		switch x := v.(type) {
+		case int64:
+			if sum, ok := acc.sum.(float64); ok {
+				acc.sum = sum + float64(x)
+			} else {
+				sum, _ := acc.sum.(int64)
+				acc.sum = sum + x
+			}
+		case float64:
+			switch sum := acc.sum.(type) {
+			case int64:
+				acc.sum = float64(sum) + x
+			case float64:
+				acc.sum = sum + x
+			default:
+				acc.sum = x
+			}
+		default:
+			return fmt.Errorf("%w: %s of a value of type %T", ErrUnsupportedAggregation, fn, v)
+		}
+	case "min", "max":
+		if acc.min == nil {
+			acc.min, acc.max = v, v
+			return nil
+		}
+		cmpMin, okMin := compareValues(v, acc.min)
+		cmpMax, okMax := compareValues(v, acc.max)
+		if !okMin || !okMax {
+			return fmt.Errorf("%w: %s of values of type %T", ErrUnsupportedAggregation, fn, v)
+		}
+		if cmpMin < 0 {
+			acc.min = v
+		}
+		if cmpMax > 0 {
+			acc.max = v
+		}
+	}
+	return nil
+}
+
+func (acc *accumulator) result(fn string) interface{} {
+	switch fn {
+	case "count":
+		return acc.count
+	case "sum":
+		return acc.sum
+	case "min":
+		return acc.min
+	case "max":
+		return acc.max
+	}
+	// the average of integers is an integer, as in immudb
+	switch sum := acc.sum.(type) {
+	case int64:
+		return sum / acc.count
+	case float64:
+		return sum / float64(acc.count)
+	}
+	return nil
+}
+
+// group is a group of rows with the same values of the grouped columns
+type group struct {
+	a      *aggregation
+	key    []interface{}
+	rows   int64
+	accs   []accumulator
+	values []interface{}
+}
+
+// value returns the value of the operand for the group
+func (g *group) value(o operand) (interface{}, error) {
+	switch {
+	case o.agg != nil:
+		for i, agg := range g.a.aggregates {
+			if agg == *o.agg {
+				if agg.arg == "*" {
+					return g.rows, nil
+				}
+				return g.accs[i].result(agg.fn), nil
+			}
+		}
+	case o.column != "":
+		for i, name := range g.a.groupBy {
+			if unqualified(name) == o.column {
+				return g.key[i], nil
+			}
+		}
+		for i, item := range g.a.items {
+			if item.name == o.column {
+				return g.values[i], nil
+			}
+		}
+		return nil, fmt.Errorf("%w: unknown column %s", ErrUnsupportedAggregation, o.column)
+	}
+	return o.value, nil
+}
+
+// run reads the rows of the query without grouping and aggregates them
+func (a *aggregation) run(db *gorm.DB) (*resultRows, error) {
+	// the columns read: the grouped ones, then the arguments of the aggregates
+	columns := append([]string(nil), a.groupBy...)
+	positions := map[string]int{}
+	for i, name := range a.groupBy {
+		positions[unqualified(name)] = i
+	}
+	for _, agg := range a.aggregates {
+		if _, ok := positions[unqualified(agg.arg)]; !ok && agg.arg != "*" {
+			positions[unqualified(agg.arg)] = len(columns)
+			columns = append(columns, agg.arg)
+		}
+	}
+	floats := make([]bool, len(columns))
+	if s := db.Statement.Schema; s != nil {
+		for i, name := range columns {
+			if field := s.LookUpField(unqualified(name)); field != nil {
+				floats[i] = isFloatField(field)
+			}
+		}
+	}
+
+	tx := db.Session(&gorm.Session{Initialized: true})
+	for _, name := range []string{"SELECT", "GROUP BY", "ORDER BY", "LIMIT"} {
+		delete(tx.Statement.Clauses, name)
+	}
+	tx.Statement.Selects = columns
+	tx.Statement.Omits = nil
+	rows, err := tx.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		groups = map[string]*group{}
+		keys   []string
+		values = make([]interface{}, len(columns))
+		ptrs   = make([]interface{}, len(columns))
+	)
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			if n, ok := v.(int64); ok && floats[i] {
+				values[i] = decodeFloat(n)
+			}
+		}
+
+		key := fmt.Sprintf("%#v", values[:len(a.groupBy)])
+		g, ok := groups[key]
+		if !ok {
+			g = &group{a: a, key: append([]interface{}(nil), values[:len(a.groupBy)]...), accs: make([]accumulator, len(a.aggregates))}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.rows++
+		for i, agg := range a.aggregates {
+			if agg.arg == "*" {
+				continue
+			}
+			if err := g.accs[i].add(agg.fn, values[positions[unqualified(agg.arg)]]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]*group, 0, len(groups))
+	for _, key := range keys {
+		g := groups[key]
+		g.values = make([]interface{}, len(a.items))
+		for i, item := range a.items {
+			if g.values[i], err = g.value(item.expr); err != nil {
+				return nil, err
+			}
+		}
+		if a.having != nil {
+			ok, err := a.having.eval(g)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		result = append(result, g)
+	}
+
+	if err := a.sort(result); err != nil {
+		return nil, err
+	}
+	if a.limit.Offset > 0 {
+		if a.limit.Offset > len(result) {
+			a.limit.Offset = len(result)
+		}
+		result = result[a.limit.Offset:]
+	}
+	if a.limit.Limit > 0 && a.limit.Limit < len(result) {
+		result = result[:a.limit.Limit]
+	}
+
+	out := &resultRows{columns: make([]string, len(a.items)), values: make([][]driver.Value, len(result))}
+	for i, item := range a.items {
+		out.columns[i] = item.name
+	}
+	for i, g := range result {
+		out.values[i] = make([]driver.Value, len(g.values))
+		for j, v := range g.values {
+			out.values[i][j] = v
+		}
+	}
+	return out, nil
+}
+
+// sort orders the groups by the order of the query, by their grouped values otherwise
+func (a *aggregation) sort(groups []*group) error {
+	keys := a.orderBy
+	if len(keys) == 0 {
+		for _, name := range a.groupBy {
+			keys = append(keys, orderKey{expr: operand{column: unqualified(name)}})
+		}
+	}
+
+	var err error
+	sort.SliceStable(groups, func(i, j int) bool {
+		for _, key := range keys {
+			x, errX := groups[i].value(key.expr)
+			y, errY := groups[j].value(key.expr)
+			if errX != nil || errY != nil {
+				if err == nil {
+					err = errX
+					if err == nil {
+						err = errY
+					}
+				}
+				return false
+			}
+			if cmp, _ := compareValues(x, y); cmp != 0 {
+				return (cmp < 0) != key.desc
+			}
+		}
+		return false
+	})
+	return err
+}
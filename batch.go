@@ -0,0 +1,113 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"reflect"
+)
+
+// Each INSERT statement is committed as one immudb transaction, whose number of entries is bounded by the server: a
+// row takes an entry for the primary key and one for each other index of the table. The batches of CreateInBatches,
+// which are committed one by one anyway, are split into the fewest statements fitting in a transaction when the
+// statement is marked by SplitBatches or runs with a CreateBatchSize. The rows of a single Create are committed
+// together or not at all, so they fail if they do not fit.
+
+const (
+	// defaultMaxTxEntries is the number of entries of a transaction of immudb servers with the default options
+	defaultMaxTxEntries = 1024
+	// splitBatchesSetting marks the statements whose rows may be split into several transactions, see SplitBatches
+	splitBatchesSetting = "immudb:split_batches"
+)
+
+// SplitBatches lets the batches of CreateInBatches that do not fit in a transaction be split into several statements:
+//
+//	err = immugorm.SplitBatches(db).CreateInBatches(&readings, 500).Error
+func SplitBatches(db *gorm.DB) *gorm.DB {
+	return db.Set(splitBatchesSetting, true)
+}
+
+func (dialector *Dialector) maxTxEntries() int {
+	if dialector.cfg != nil && dialector.cfg.MaxTxEntries > 0 {
+		return dialector.cfg.MaxTxEntries
+	}
+	return defaultMaxTxEntries
+}
+
+// createInBatches runs create on batches of the rows of a batch of CreateInBatches, each of them fitting in a
+// transaction
+func (dialector *Dialector) createInBatches(create func(*gorm.DB)) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		stmt := db.Statement
+		rv := stmt.ReflectValue
+		if db.Error != nil || stmt.Schema == nil || stmt.SQL.Len() > 0 || db.DryRun ||
+			!(rv.Kind() == reflect.Slice || (rv.Kind() == reflect.Array && rv.CanAddr())) {
+			create(db)
+			return
+		}
+
+		entries := len(dialector.tableIndexes(stmt.Schema))
+		if entries == 0 {
+			entries = 1
+		}
+		size := dialector.maxTxEntries() / entries
+		if size < 1 {
+			size = 1
+		}
+		if rv.Len() <= size {
+			create(db)
+			return
+		}
+		if !splitsBatches(stmt) {
+			db.AddError(fmt.Errorf("%w: %d rows of table %s take %d entries, more than the %d of a transaction, use SplitBatches or CreateInBatches with batches of at most %d rows",
+				ErrTooManyRows, rv.Len(), stmt.Table, rv.Len()*entries, dialector.maxTxEntries(), size))
+			return
+		}
+
+		dest := stmt.Dest
+		defer func() {
+			stmt.Dest = dest
+			stmt.ReflectValue = rv
+		}()
+		var rowsAffected int64
+		for i := 0; i < rv.Len() && db.Error == nil; i += size {
+			end := i + size
+			if end > rv.Len() {
+				end = rv.Len()
+			}
+			// the batch shares the elements of the rows, which get the generated primary keys
+			stmt.ReflectValue = rv.Slice(i, end)
+			stmt.Dest = stmt.ReflectValue.Interface()
+			stmt.SQL.Reset()
+			stmt.Vars = nil
+			create(db)
+			rowsAffected += db.RowsAffected
+		}
+		db.RowsAffected = rowsAffected
+	}
+}
+
+// splitsBatches reports whether the rows of the statement may be committed in several transactions: Create runs in
+// batches with a CreateBatchSize, and SplitBatches marks the statements of CreateInBatches
+func splitsBatches(stmt *gorm.Statement) bool {
+	if stmt.DB.CreateBatchSize > 0 {
+		return true
+	}
+	_, ok := stmt.Settings.Load(splitBatchesSetting)
+	return ok
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxDecimalScale is the maximum number of fractional digits of a Decimal
+	MaxDecimalScale = 19
+	// decimalIntegerDigits is the number of digits of the largest int64
+	decimalIntegerDigits = 19
+	// decimalColumnSize is the size of the VARCHAR column holding an encoded Decimal
+	decimalColumnSize = 1 + decimalIntegerDigits + MaxDecimalScale + 1
+)
+
+var decimalPattern = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)$`)
+
+// Decimal is an exact fixed-point number, for amounts of money and other values that cannot be rounded.
+// It is stored in a VARCHAR column with an encoding whose order is the numeric order, so comparisons
+// and ORDER BY work in queries, provided the parameters are Decimal values too.
+//
+// Positive numbers and zero are encoded as P, the integer part padded to 19 digits and the fractional digits.
+// Negative numbers are encoded as N, the nines' complement of the same digits and a final ~.
+type Decimal struct {
+	unscaled int64
+	scale    int32
+}
+
+// NewDecimal returns the decimal unscaled * 10^-scale
+func NewDecimal(unscaled int64, scale int32) Decimal {
+	for ; scale < 0; scale++ {
+		unscaled *= 10
+	}
+	return Decimal{unscaled: unscaled, scale: scale}
+}
+
+// ParseDecimal parses a decimal number like -12.50
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if !decimalPattern.MatchString(s) {
+		return Decimal{}, fmt.Errorf("%w: %q", ErrInvalidDecimal, s)
+	}
+	unscaled, err := strconv.ParseInt(strings.Replace(s, ".", "", 1), 10, 64)
+	if err != nil {
+		return Decimal{}, fmt.Errorf("%w: %q is out of range", ErrInvalidDecimal, s)
+	}
+	var scale int32
+	if i := strings.Index(s, "."); i >= 0 {
+		scale = int32(len(s) - i - 1)
+	}
+	if scale > MaxDecimalScale {
+		return Decimal{}, fmt.Errorf("%w: %q has more than %d fractional digits", ErrInvalidDecimal, s, MaxDecimalScale)
+	}
+	return Decimal{unscaled: unscaled, scale: scale}, nil
+}
+
+// Unscaled returns the unscaled value and the scale of the decimal
+func (d Decimal) Unscaled() (int64, int32) {
+	return d.unscaled, d.scale
+}
+
+// parts returns the sign and the digits of the integer and fractional parts, without leading and trailing zeros
+func (d Decimal) parts() (negative bool, integer string, fraction string) {
+	negative = d.unscaled < 0
+	abs := uint64(d.unscaled)
+	if negative {
+		abs = -abs
+	}
+	digits := strconv.FormatUint(abs, 10)
+	if scale := int(d.scale); scale > 0 {
+		if len(digits) <= scale {
+			digits = strings.Repeat("0", scale-len(digits)+1) + digits
+		}
+		integer, fraction = digits[:len(digits)-scale], digits[len(digits)-scale:]
+	} else {
+		integer = digits
+	}
+	integer = strings.TrimLeft(integer, "0")
+	fraction = strings.TrimRight(fraction, "0")
+	if integer == "" && fraction == "" {
+		negative = false
+	}
+	return
+}
+
+func (d Decimal) String() string {
+	negative, integer, fraction := d.parts()
+	if integer == "" {
+		integer = "0"
+	}
+	s := integer
+	if fraction != "" {
+		s += "." + fraction
+	}
+	if negative {
+		s = "-" + s
+	}
+	return s
+}
+
+// Float64 returns the nearest float64 value of the decimal
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// Cmp returns -1, 0 or 1 if d is less than, equal to or greater than o
+func (d Decimal) Cmp(o Decimal) int {
+	return strings.Compare(d.encode(), o.encode())
+}
+
+func (d Decimal) encode() string {
+	negative, integer, fraction := d.parts()
+	integer = strings.Repeat("0", decimalIntegerDigits-len(integer)) + integer
+	if !negative {
+		return "P" + integer + fraction
+	}
+	return "N" + ninesComplement(integer) + ninesComplement(fraction) + "~"
+}
+
+func decodeDecimal(s string) (Decimal, error) {
+	if len(s) < 1+decimalIntegerDigits {
+		return Decimal{}, fmt.Errorf("%w: %q is not an encoded decimal", ErrInvalidDecimal, s)
+	}
+	digits := s[1:]
+	switch s[0] {
+	case 'P':
+	case 'N':
+		digits = ninesComplement(strings.TrimSuffix(digits, "~"))
+	default:
+		return Decimal{}, fmt.Errorf("%w: %q is not an encoded decimal", ErrInvalidDecimal, s)
+	}
+	unscaled, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Decimal{}, fmt.Errorf("%w: %q is not an encoded decimal", ErrInvalidDecimal, s)
+	}
+	if s[0] == 'N' {
+		unscaled = -unscaled
+	}
+	return Decimal{unscaled: unscaled, scale: int32(len(digits) - decimalIntegerDigits)}, nil
+}
+
+func ninesComplement(digits string) string {
+	b := []byte(digits)
+	for i, c := range b {
+		b[i] = '9' - c + '0'
+	}
+	return string(b)
+}
+
+func (d Decimal) Value() (driver.Value, error) {
+	if d.scale > MaxDecimalScale {
+		return nil, fmt.Errorf("%w: more than %d fractional digits", ErrInvalidDecimal, MaxDecimalScale)
+	}
+	return d.encode(), nil
+}
+
+func (d *Decimal) Scan(src interface{}) (err error) {
+	switch v := src.(type) {
+	case string:
+		*d, err = decodeDecimal(v)
+	case []byte:
+		*d, err = decodeDecimal(string(v))
+	case nil:
+		*d = Decimal{}
+	default:
+		err = fmt.Errorf("%w: unsupported value of type %T", ErrInvalidDecimal, src)
+	}
+	return
+}
+
+func (Decimal) GormDataType() string {
+	return "decimal"
+}
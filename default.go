@@ -0,0 +1,118 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var currentTimestampDefaults = map[string]bool{
+	"current_timestamp":   true,
+	"current_timestamp()": true,
+	"localtimestamp":      true,
+	"now()":               true,
+}
+
+var defaultTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// setDefaultValues fills the zero fields having a default value that gorm leaves to the database,
+// like function defaults, since immudb has no column defaults.
+func (dialector *Dialector) setDefaultValues(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+
+	selectColumns, restricted := db.Statement.SelectAndOmitColumns(true, false)
+	for _, field := range db.Statement.Schema.FieldsWithDefaultDBValue {
+		if v, ok := selectColumns[field.DBName]; (ok && !v) || (!ok && restricted) {
+			continue
+		}
+		if hasUUIDDefault(field) {
+			// generated for each record by generateUUIDs
+			continue
+		}
+
+		value, ok, err := defaultValueOf(db, field)
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		if !ok {
+			continue
+		}
+
+		setDefault := func(rv reflect.Value) {
+			if _, isZero := field.ValueOf(db.Statement.Context, rv); isZero {
+				db.AddError(field.Set(db.Statement.Context, rv, value))
+			}
+		}
+		switch db.Statement.ReflectValue.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < db.Statement.ReflectValue.Len(); i++ {
+				if rv := reflect.Indirect(db.Statement.ReflectValue.Index(i)); rv.IsValid() {
+					setDefault(rv)
+				}
+			}
+		case reflect.Struct:
+			setDefault(db.Statement.ReflectValue)
+		}
+	}
+}
+
+// defaultValueOf evaluates the default value of a field on the client side
+func defaultValueOf(db *gorm.DB, field *schema.Field) (interface{}, bool, error) {
+	if field.DefaultValueInterface != nil {
+		return field.DefaultValueInterface, true, nil
+	}
+
+	value := strings.TrimSpace(field.DefaultValue)
+	lower := strings.ToLower(value)
+	if value == "" || value == "(-)" || lower == "null" {
+		return nil, false, nil
+	}
+
+	if currentTimestampDefaults[lower] {
+		return db.Statement.DB.NowFunc(), true, nil
+	}
+
+	if len(value) > 1 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+		if field.DataType != schema.Time {
+			return value, true, nil
+		}
+	}
+
+	if field.DataType == schema.Time {
+		for _, layout := range defaultTimeLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t, true, nil
+			}
+		}
+	}
+
+	return nil, false, fmt.Errorf("%w: %s for field %s", ErrUnsupportedDefaultValue, field.DefaultValue, field.Name)
+}
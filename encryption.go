@@ -0,0 +1,342 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"fmt"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Fields tagged with immudb:"encrypted" are encrypted on the client with AES-GCM before being sent to immudb, and
+// decrypted when they are read. immudb only stores the ciphertext, which is what verification runs over. The nonce
+// of indexed fields, or of fields tagged with immudb:"encrypted,deterministic", is derived from the value, so that
+// equal values have equal ciphertexts and can be looked up by equality.
+
+const (
+	// encryptionOverhead is the size of the nonce and of the authentication tag added to the encrypted values
+	encryptionOverhead = 12 + 16
+	immudbTag          = "immudb"
+)
+
+// KeyProvider provides the keys of the encrypted fields
+type KeyProvider interface {
+	// Key returns the AES key, 16, 24 or 32 bytes long, of the column of table
+	Key(table, column string) ([]byte, error)
+}
+
+// StaticKey is a KeyProvider returning the same key for every column
+type StaticKey []byte
+
+func (k StaticKey) Key(table, column string) ([]byte, error) {
+	return k, nil
+}
+
+// tagOptions returns the options of the immudb tag of the field
+func tagOptions(field *schema.Field) map[string]bool {
+	options := map[string]bool{}
+	for _, option := range strings.Split(field.Tag.Get(immudbTag), ",") {
+		if option = strings.TrimSpace(option); option != "" {
+			options[strings.ToLower(option)] = true
+		}
+	}
+	return options
+}
+
+func isEncryptedField(field *schema.Field) bool {
+	return tagOptions(field)["encrypted"]
+}
+
+// isEncryptedColumn reports whether the column of the schema holds encrypted values
+func isEncryptedColumn(s *schema.Schema, name string) bool {
+	if s == nil {
+		return false
+	}
+	field := s.LookUpField(name)
+	return field != nil && isEncryptedField(field)
+}
+
+// encryptedColumnSize returns the size of the BLOB column of an encrypted field, 0 if it has no size
+func encryptedColumnSize(field *schema.Field) int {
+	if field.Size <= 0 {
+		return 0
+	}
+	return field.Size + encryptionOverhead
+}
+
+// fieldCipher encrypts and decrypts the values of an encrypted field
+type fieldCipher struct {
+	dialector     *Dialector
+	table         string
+	column        string
+	deterministic bool
+}
+
+func (c *fieldCipher) aead() (cipher.AEAD, []byte, error) {
+	if c.dialector.cfg == nil || c.dialector.cfg.KeyProvider == nil {
+		return nil, nil, fmt.Errorf("%w: column %s of table %s", ErrMissingKeyProvider, c.column, c.table)
+	}
+	key, err := c.dialector.cfg.KeyProvider.Key(c.table, c.column)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, key, err
+}
+
+// additionalData binds the ciphertext to its column, it cannot be moved to another one
+func (c *fieldCipher) additionalData() []byte {
+	return []byte(c.table + "." + c.column)
+}
+
+func (c *fieldCipher) encrypt(plaintext []byte) ([]byte, error) {
+	aead, key, err := c.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if c.deterministic {
+		// synthetic nonce, the MAC of the value under a key derived from the column key
+		derived := hmac.New(sha256.New, key)
+		derived.Write([]byte("immugorm deterministic nonce"))
+		mac := hmac.New(sha256.New, derived.Sum(nil))
+		mac.Write(c.additionalData())
+		mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, c.additionalData()), nil
+}
+
+func (c *fieldCipher) decrypt(ciphertext []byte) ([]byte, error) {
+	aead, _, err := c.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("%w: column %s of table %s", ErrDecryption, c.column, c.table)
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], c.additionalData())
+	if err != nil {
+		return nil, fmt.Errorf("%w: column %s of table %s", ErrDecryption, c.column, c.table)
+	}
+	return plaintext, nil
+}
+
+// encryptedValue is the value sent to immudb for encrypted fields
+type encryptedValue struct {
+	cipher *fieldCipher
+	data   []byte
+}
+
+func (v encryptedValue) Value() (driver.Value, error) {
+	return v.cipher.encrypt(v.data)
+}
+
+// encryptedColumn scans the ciphertext of an encrypted column
+type encryptedColumn struct {
+	data  []byte
+	valid bool
+}
+
+func (c *encryptedColumn) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		c.data = append(c.data[:0], v...)
+	case nil:
+		c.data = nil
+	default:
+		return fmt.Errorf("unsupported value %v of type %T for an encrypted column", src, src)
+	}
+	c.valid = src != nil
+	return nil
+}
+
+var encryptedColumnPool = &sync.Pool{
+	New: func() interface{} {
+		return new(encryptedColumn)
+	},
+}
+
+// isIndexedField reports whether the field is part of the primary key or of an index of the schema
+func isIndexedField(s *schema.Schema, field *schema.Field) bool {
+	if field.PrimaryKey || field.Unique {
+		return true
+	}
+	for _, idx := range s.ParseIndexes() {
+		for _, f := range idx.Fields {
+			if f.Field == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// prepareEncryptedField makes the field send the ciphertext of its value and decrypt the scanned one. Only string
+// and []byte fields are supported.
+func (dialector *Dialector) prepareEncryptedField(s *schema.Schema, field *schema.Field) {
+	switch field.IndirectFieldType.Kind() {
+	case reflect.String:
+	case reflect.Slice:
+		if field.IndirectFieldType.Elem().Kind() != reflect.Uint8 {
+			return
+		}
+	default:
+		return
+	}
+
+	c := &fieldCipher{
+		dialector:     dialector,
+		table:         s.Table,
+		column:        field.DBName,
+		deterministic: isIndexedField(s, field) || tagOptions(field)["deterministic"],
+	}
+	dialector.fieldCiphers.Store(field, c)
+
+	valueOf := field.ValueOf
+	field.ValueOf = func(ctx context.Context, rv reflect.Value) (interface{}, bool) {
+		value, zero := valueOf(ctx, rv)
+		if data, ok := lobData(value); ok {
+			return encryptedValue{cipher: c, data: data}, zero
+		}
+		return nil, zero
+	}
+
+	set := field.Set
+	field.Set = func(ctx context.Context, rv reflect.Value, v interface{}) error {
+		if _, ok := v.(encryptedValue); ok {
+			// assigned by gorm after a map update, the field already holds the value
+			return nil
+		}
+		column, ok := v.(*encryptedColumn)
+		if !ok {
+			return set(ctx, rv, v)
+		}
+		if !column.valid {
+			return set(ctx, rv, nil)
+		}
+		data, err := c.decrypt(column.data)
+		if err != nil {
+			return err
+		}
+		if field.IndirectFieldType.Kind() == reflect.String {
+			return set(ctx, rv, string(data))
+		}
+		return set(ctx, rv, data)
+	}
+	field.NewValuePool = encryptedColumnPool
+}
+
+// cipherOf returns the cipher of an encrypted field
+func (dialector *Dialector) cipherOf(field *schema.Field) (*fieldCipher, bool) {
+	c, ok := dialector.fieldCiphers.Load(field)
+	if !ok {
+		return nil, false
+	}
+	return c.(*fieldCipher), true
+}
+
+// valueOf returns the value sent for v, a value of the encrypted field
+func (c *fieldCipher) valueOf(v interface{}) interface{} {
+	if _, ok := v.(encryptedValue); ok {
+		return v
+	}
+	if data, ok := lobData(v); ok {
+		return encryptedValue{cipher: c, data: data}
+	}
+	return v
+}
+
+// rewriteEncryptedConditions encrypts the values compared with deterministically encrypted columns in equality and
+// IN conditions, like the ones of map conditions. Conditions written as SQL strings are left as they are.
+func (dialector *Dialector) rewriteEncryptedConditions(s *schema.Schema, expr clause.Expression) clause.Expression {
+	column := func(c interface{}) (*fieldCipher, bool) {
+		var name string
+		switch v := c.(type) {
+		case string:
+			name = v
+		case clause.Column:
+			if v.Raw || (v.Table != "" && v.Table != clause.CurrentTable && v.Table != s.Table) {
+				return nil, false
+			}
+			name = v.Name
+		default:
+			return nil, false
+		}
+		field := s.LookUpField(name)
+		if field == nil {
+			return nil, false
+		}
+		fc, ok := dialector.cipherOf(field)
+		return fc, ok && fc.deterministic
+	}
+
+	switch v := expr.(type) {
+	case clause.Eq:
+		if c, ok := column(v.Column); ok {
+			v.Value = c.valueOf(v.Value)
+		}
+		return v
+	case clause.Neq:
+		if c, ok := column(v.Column); ok {
+			v.Value = c.valueOf(v.Value)
+		}
+		return v
+	case clause.IN:
+		if c, ok := column(v.Column); ok {
+			values := make([]interface{}, len(v.Values))
+			for i, value := range v.Values {
+				values[i] = c.valueOf(value)
+			}
+			v.Values = values
+		}
+		return v
+	case clause.AndConditions:
+		return clause.AndConditions{Exprs: dialector.rewriteEncryptedExprs(s, v.Exprs)}
+	case clause.OrConditions:
+		return clause.OrConditions{Exprs: dialector.rewriteEncryptedExprs(s, v.Exprs)}
+	case clause.NotConditions:
+		return clause.NotConditions{Exprs: dialector.rewriteEncryptedExprs(s, v.Exprs)}
+	}
+	return expr
+}
+
+func (dialector *Dialector) rewriteEncryptedExprs(s *schema.Schema, exprs []clause.Expression) []clause.Expression {
+	rewritten := make([]clause.Expression, len(exprs))
+	for idx, expr := range exprs {
+		rewritten[idx] = dialector.rewriteEncryptedConditions(s, expr)
+	}
+	return rewritten
+}
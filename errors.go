@@ -23,4 +23,25 @@ var (
 	ErrNotImplemented            = errors.New("not implemented")
 	ErrCorruptedData             = errors.New("corrupted data")
 	ErrTimeTravelNotAvailable    = errors.New("time travel is not available if verify flag is provided. This will change soon")
+	ErrGroupByNotVerifiable      = errors.New("grouped queries cannot be verified")
+	ErrInvalidMigration          = errors.New("invalid migration")
+	ErrMigrationHistoryMismatch  = errors.New("recorded migration history differs from the provided migrations")
+	ErrMigrationInterrupted      = errors.New("migration interrupted")
+	ErrUnsupportedSchemaChange   = errors.New("schema change not supported by immudb")
+	ErrInvalidIndexedColumnSize  = errors.New("invalid size for indexed column")
+	ErrUnsupportedDefaultValue   = errors.New("unsupported default value")
+	ErrForeignKeyViolation       = errors.New("foreign key constraint violation")
+	ErrAmbiguousRecord           = errors.New("record matches several stored records")
+	ErrInvalidDecimal            = errors.New("invalid decimal")
+	ErrIntegerOverflow           = errors.New("integer overflow")
+	ErrUnsupportedFloatParameter = errors.New("unsupported float parameter")
+	ErrLobInTransaction          = errors.New("large objects cannot be stored inside a transaction")
+	ErrTooManyRows               = errors.New("rows do not fit in an immudb transaction")
+	ErrMissingKeyProvider        = errors.New("no key provider configured for encrypted field")
+	ErrDecryption                = errors.New("decryption failed")
+	ErrUnsupportedAggregation    = errors.New("unsupported aggregation")
+	ErrUnsupportedOrder          = errors.New("unsupported order")
+	ErrInvalidCursor             = errors.New("invalid pagination cursor")
+	ErrUnsupportedSubquery       = errors.New("unsupported subquery")
+	ErrUnsupportedJoin           = errors.New("unsupported join")
 )
@@ -0,0 +1,225 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"database/sql"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// immudb has no floating point type. Float fields are stored in INTEGER columns holding the bits of the float64
+// value, mapped so that the order of the integers is the order of the floats. Float query parameters assigned to
+// or compared with float columns are mapped the same way, so comparisons and ORDER BY work as expected and values
+// are stored exactly. Any other float parameter has no INTEGER counterpart, like in arithmetic, and is refused.
+
+func encodeFloat(f float64) int64 {
+	bits := int64(math.Float64bits(f))
+	if bits < 0 {
+		// negative floats grow with their magnitude, flipping it reverses their order
+		return bits ^ math.MaxInt64
+	}
+	return bits
+}
+
+func decodeFloat(i int64) float64 {
+	if i < 0 {
+		i ^= math.MaxInt64
+	}
+	return math.Float64frombits(uint64(i))
+}
+
+// encodeFloatVar maps float parameters to their stored INTEGER value
+func encodeFloatVar(v interface{}) (interface{}, bool) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return v, false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return encodeFloat(rv.Float()), true
+	}
+	return v, false
+}
+
+// isFloatColumn reports whether the field is stored as the mapped bits of a float
+func isFloatColumn(field *schema.Field) bool {
+	return isFloatField(field) && !isEncryptedField(field) && !isUUIDField(field) && !isLobField(field) &&
+		!reflect.PtrTo(field.IndirectFieldType).Implements(scannerType)
+}
+
+// floatOperandRegexp matches the column a parameter is compared with, from the SQL written before the parameter
+var floatOperandRegexp = regexp.MustCompile(`(?i)([\w."]+)\s*(?:=|<>|!=|<=|>=|<|>|\bIN\s*\((?:\s*\?\s*,)*)\s*\(?\s*$`)
+
+// floatOperand reports whether the parameter following sql is compared with a float column of the statement model,
+// or of one of its relationships
+func floatOperand(stmt *gorm.Statement, sql string) bool {
+	if stmt.Schema == nil {
+		return false
+	}
+	if len(sql) > 256 {
+		sql = sql[len(sql)-256:]
+	}
+	m := floatOperandRegexp.FindStringSubmatch(sql)
+	if m == nil {
+		return false
+	}
+
+	s, name := stmt.Schema, strings.ReplaceAll(m[1], `"`, "")
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		table := name[:i]
+		name = name[i+1:]
+		if rel, ok := s.Relationships.Relations[table]; ok {
+			s = rel.FieldSchema
+		} else if table != s.Table && table != stmt.Table {
+			return false
+		}
+	}
+	field := s.LookUpField(name)
+	return field != nil && isFloatColumn(field)
+}
+
+// encodeFloatVars maps the float values assigned to the float columns, by VALUES and SET
+func encodeFloatVars(s *schema.Schema, expr clause.Expression) clause.Expression {
+	if s == nil {
+		return expr
+	}
+	isFloat := func(column clause.Column) bool {
+		field := s.LookUpField(column.Name)
+		return field != nil && isFloatColumn(field)
+	}
+
+	switch v := expr.(type) {
+	case clause.Values:
+		floats := make([]bool, len(v.Columns))
+		for i, column := range v.Columns {
+			floats[i] = isFloat(column)
+		}
+		values := make([][]interface{}, len(v.Values))
+		for i, row := range v.Values {
+			values[i] = make([]interface{}, len(row))
+			for j, value := range row {
+				if j < len(floats) && floats[j] {
+					value, _ = encodeFloatVar(value)
+				}
+				values[i][j] = value
+			}
+		}
+		v.Values = values
+		return v
+	case clause.Set:
+		assignments := make(clause.Set, len(v))
+		for i, assignment := range v {
+			if isFloat(assignment.Column) {
+				assignment.Value, _ = encodeFloatVar(assignment.Value)
+			}
+			assignments[i] = assignment
+		}
+		return assignments
+	}
+	return expr
+}
+
+func buildValues(c clause.Clause, builder clause.Builder) {
+	if stmt, ok := builder.(*gorm.Statement); ok {
+		c.Expression = encodeFloatVars(stmt.Schema, c.Expression)
+	}
+	c.Build(builder)
+}
+
+func buildSet(c clause.Clause, builder clause.Builder) {
+	if stmt, ok := builder.(*gorm.Statement); ok {
+		c.Expression = encodeFloatVars(stmt.Schema, c.Expression)
+	}
+	c.Build(builder)
+}
+
+// floatColumn scans the INTEGER value of a float column
+type floatColumn float64
+
+func (f *floatColumn) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		*f = floatColumn(decodeFloat(v))
+	case nil:
+		*f = 0
+	default:
+		return fmt.Errorf("unsupported value %v of type %T for a float column", src, src)
+	}
+	return nil
+}
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+	floatColumnPool = &sync.Pool{
+		New: func() interface{} {
+			return new(*floatColumn)
+		},
+	}
+)
+
+// prepareSchema adapts the fields of the schema, and of its relationships, to immudb: float fields are scanned
+// through floatColumn, UUID fields through uuidColumn, large object fields hold their value, encrypted fields are
+// encrypted and zero values of serialized fields are serialized as well
+func (dialector *Dialector) prepareSchema(s *schema.Schema) {
+	if s == nil {
+		return
+	}
+	if _, ok := dialector.preparedSchemas.Load(s); ok {
+		return
+	}
+	dialector.preparedSchemas.Store(s, true)
+
+	for _, field := range s.Fields {
+		if field.Serializer != nil {
+			serializeZeroValues(field)
+		}
+		if isEncryptedField(field) {
+			dialector.prepareEncryptedField(s, field)
+			continue
+		}
+		if isUUIDField(field) {
+			prepareUUIDField(field)
+			continue
+		}
+		if isLobField(field) {
+			dialector.prepareLobField(field)
+			continue
+		}
+		if isFloatColumn(field) {
+			field.NewValuePool = floatColumnPool
+		}
+	}
+	for _, rel := range s.Relationships.Relations {
+		dialector.prepareSchema(rel.FieldSchema)
+	}
+}
+
+func (dialector *Dialector) prepareStatementSchema(db *gorm.DB) {
+	dialector.prepareSchema(db.Statement.Schema)
+}
@@ -0,0 +1,429 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// foreignKeys holds the constraints enforced on the client side. immudb has no foreign keys, so the
+// constraints are collected from the relationships of the models seen by migrations and statements.
+type foreignKeys struct {
+	mu          sync.RWMutex
+	schemas     map[*schema.Schema]bool
+	constraints map[string]*schema.Constraint
+	dropped     map[string]bool
+}
+
+// foreignKeysTxSetting holds the number of rows of the deletes running in a transaction started for the actions of
+// their constraints, which returns no number of affected rows, see applyForeignKeysOnDelete
+const foreignKeysTxSetting = "immudb:foreign_keys_tx"
+
+// foreignKeysPaused marks the context of the sessions whose statements skip the enforcement. Being in the context,
+// it is kept by the sessions gorm derives from them, like the ones saving associations.
+type foreignKeysPaused struct{}
+
+func constraintKey(table, name string) string {
+	return table + "." + name
+}
+
+func (fk *foreignKeys) register(s *schema.Schema) {
+	fk.mu.Lock()
+	defer fk.mu.Unlock()
+	fk.registerLocked(s)
+}
+
+func (fk *foreignKeys) registerLocked(s *schema.Schema) {
+	if s == nil || fk.schemas[s] {
+		return
+	}
+	if fk.schemas == nil {
+		fk.schemas = map[*schema.Schema]bool{}
+		fk.constraints = map[string]*schema.Constraint{}
+		fk.dropped = map[string]bool{}
+	}
+	fk.schemas[s] = true
+
+	for _, rel := range s.Relationships.Relations {
+		// join tables and polymorphic references are not enforced
+		if rel.JoinTable == nil && rel.Polymorphic == nil {
+			if c := rel.ParseConstraint(); c != nil && c.Schema != nil && c.ReferenceSchema != nil {
+				key := constraintKey(c.Schema.Table, c.Name)
+				if _, ok := fk.constraints[key]; !ok {
+					fk.constraints[key] = c
+				}
+			}
+		}
+		fk.registerLocked(rel.FieldSchema)
+	}
+}
+
+// holding returns the constraints whose foreign keys are columns of table
+func (fk *foreignKeys) holding(table string) []*schema.Constraint {
+	return fk.filter(func(c *schema.Constraint) bool { return c.Schema.Table == table })
+}
+
+// referencing returns the constraints whose foreign keys reference columns of table
+func (fk *foreignKeys) referencing(table string) []*schema.Constraint {
+	return fk.filter(func(c *schema.Constraint) bool { return c.ReferenceSchema.Table == table })
+}
+
+func (fk *foreignKeys) filter(match func(c *schema.Constraint) bool) []*schema.Constraint {
+	fk.mu.RLock()
+	defer fk.mu.RUnlock()
+
+	keys := make([]string, 0, len(fk.constraints))
+	for key, c := range fk.constraints {
+		if !fk.dropped[key] && match(c) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	constraints := make([]*schema.Constraint, len(keys))
+	for i, key := range keys {
+		constraints[i] = fk.constraints[key]
+	}
+	return constraints
+}
+
+// lookup reports if the constraint is defined by the registered models and if it has been dropped
+func (fk *foreignKeys) lookup(table, name string) (defined bool, dropped bool) {
+	fk.mu.RLock()
+	defer fk.mu.RUnlock()
+	key := constraintKey(table, name)
+	_, defined = fk.constraints[key]
+	return defined, fk.dropped[key]
+}
+
+func (fk *foreignKeys) setDropped(table, name string, dropped bool) {
+	fk.mu.Lock()
+	defer fk.mu.Unlock()
+	if fk.dropped == nil {
+		fk.dropped = map[string]bool{}
+	}
+	fk.dropped[constraintKey(table, name)] = dropped
+}
+
+func (dialector *Dialector) skipForeignKeys(db *gorm.DB) bool {
+	if db.Error != nil || db.Statement.Schema == nil || db.Statement.Context.Value(foreignKeysPaused{}) != nil {
+		return true
+	}
+	dialector.foreignKeys.register(db.Statement.Schema)
+	return false
+}
+
+// checkForeignKeysOnCreate ensures the rows referenced by the created records exist
+func (dialector *Dialector) checkForeignKeysOnCreate(db *gorm.DB) {
+	if dialector.skipForeignKeys(db) {
+		return
+	}
+
+	for _, c := range dialector.foreignKeys.holding(db.Statement.Schema.Table) {
+		if db.AddError(checkReferencedRows(db, c, structValues(db.Statement.Context, db.Statement.ReflectValue, c.ForeignKeys))) != nil {
+			return
+		}
+	}
+}
+
+// checkForeignKeysOnUpdate ensures the rows referenced by the updated foreign keys exist
+func (dialector *Dialector) checkForeignKeysOnUpdate(db *gorm.DB) {
+	if dialector.skipForeignKeys(db) {
+		return
+	}
+
+	for _, c := range dialector.foreignKeys.holding(db.Statement.Schema.Table) {
+		var tuples [][]interface{}
+		switch dest := db.Statement.Dest.(type) {
+		case map[string]interface{}:
+			if tuple, ok := mapValues(dest, c.ForeignKeys); ok {
+				tuples = append(tuples, tuple)
+			}
+		default:
+			if rv := reflect.Indirect(reflect.ValueOf(dest)); rv.Kind() == reflect.Struct && rv.Type() == db.Statement.Schema.ModelType {
+				tuples = structValues(db.Statement.Context, rv, c.ForeignKeys)
+			}
+		}
+		if db.AddError(checkReferencedRows(db, c, tuples)) != nil {
+			return
+		}
+	}
+}
+
+// applyForeignKeysOnDelete applies the OnDelete action of the constraints referencing the deleted rows.
+// Soft deletes keep the referenced rows, so they are not checked. The restrictions, the actions and the delete are
+// run in the transaction of the statement, or in a new one committed by commitForeignKeysOnDelete.
+func (dialector *Dialector) applyForeignKeysOnDelete(db *gorm.DB) {
+	if dialector.skipForeignKeys(db) || (!db.Statement.Unscoped && len(db.Statement.Schema.DeleteClauses) > 0) {
+		return
+	}
+
+	constraints := dialector.foreignKeys.referencing(db.Statement.Schema.Table)
+	if len(constraints) == 0 {
+		return
+	}
+
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); !ok && !db.DryRun {
+		tx := db.Session(&gorm.Session{NewDB: true}).Begin()
+		if db.AddError(tx.Error) != nil {
+			return
+		}
+		db.Statement.ConnPool = tx.Statement.ConnPool
+		db.Statement.Settings.Store(foreignKeysTxSetting, int64(0))
+	}
+
+	rows, err := deletedRows(db, constraints)
+	if db.AddError(err) != nil || len(rows) == 0 {
+		return
+	}
+	if _, ok := db.Statement.Settings.Load(foreignKeysTxSetting); ok {
+		db.Statement.Settings.Store(foreignKeysTxSetting, int64(len(rows)))
+	}
+
+	tx := db.Session(&gorm.Session{NewDB: true})
+
+	// all restrictions are checked before any change is applied
+	for _, c := range constraints {
+		if action := onDeleteAction(c); action == "CASCADE" || action == "SET NULL" {
+			continue
+		}
+		tuples := rowValues(rows, c.References)
+		if len(tuples) == 0 {
+			continue
+		}
+
+		var count int64
+		if db.AddError(tx.Table(c.Schema.Table).Where(inCondition(c.ForeignKeys, tuples)).Count(&count).Error) != nil {
+			return
+		}
+		if count > 0 {
+			db.AddError(fmt.Errorf("%w: %d rows of table %s reference the deleted rows of table %s through %s",
+				ErrForeignKeyViolation, count, c.Schema.Table, c.ReferenceSchema.Table, c.Name))
+			return
+		}
+	}
+
+	for _, c := range constraints {
+		tuples := rowValues(rows, c.References)
+		if len(tuples) == 0 {
+			continue
+		}
+
+		switch onDeleteAction(c) {
+		case "CASCADE":
+			err = tx.Unscoped().Where(inCondition(c.ForeignKeys, tuples)).Delete(reflect.New(c.Schema.ModelType).Interface()).Error
+		case "SET NULL":
+			values := map[string]interface{}{}
+			for _, field := range c.ForeignKeys {
+				values[field.DBName] = nil
+			}
+			err = tx.Table(c.Schema.Table).Where(inCondition(c.ForeignKeys, tuples)).Updates(values).Error
+		}
+		if db.AddError(err) != nil {
+			return
+		}
+	}
+}
+
+// commitForeignKeysOnDelete commits the transaction started by applyForeignKeysOnDelete, or rolls it back if the
+// delete failed
+func (dialector *Dialector) commitForeignKeysOnDelete(db *gorm.DB) {
+	rowsAffected, ok := db.Statement.Settings.LoadAndDelete(foreignKeysTxSetting)
+	if !ok {
+		return
+	}
+	if db.Error != nil {
+		db.Rollback()
+	} else if db.Commit(); db.Error == nil {
+		db.RowsAffected = rowsAffected.(int64)
+	}
+	db.Statement.ConnPool = db.ConnPool
+}
+
+func onDeleteAction(c *schema.Constraint) string {
+	return strings.ToUpper(strings.TrimSpace(c.OnDelete))
+}
+
+// deletedRows returns the referenced columns of the rows matched by a delete statement
+func deletedRows(db *gorm.DB, constraints []*schema.Constraint) ([]map[string]interface{}, error) {
+	stmt := db.Statement
+	tx := db.Session(&gorm.Session{NewDB: true}).Table(stmt.Schema.Table)
+
+	where, hasWhere := stmt.Clauses["WHERE"]
+	if hasWhere {
+		if w, ok := where.Expression.(clause.Where); ok {
+			tx.Statement.AddClause(clause.Where{Exprs: w.Exprs})
+		}
+	}
+
+	_, queryValues := schema.GetIdentityFieldValuesMap(stmt.Context, stmt.ReflectValue, stmt.Schema.PrimaryFields)
+	if len(queryValues) > 0 {
+		column, values := schema.ToQueryValues(stmt.Schema.Table, stmt.Schema.PrimaryFieldDBNames, queryValues)
+		tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{clause.IN{Column: column, Values: values}}})
+	} else if !hasWhere && !db.AllowGlobalUpdate {
+		// gorm refuses the delete
+		return nil, nil
+	}
+
+	var columns []string
+	seen := map[string]bool{}
+	for _, c := range constraints {
+		for _, field := range c.References {
+			if !seen[field.DBName] {
+				seen[field.DBName] = true
+				columns = append(columns, field.DBName)
+			}
+		}
+	}
+
+	var rows []map[string]interface{}
+	err := tx.Select(columns).Find(&rows).Error
+	return rows, err
+}
+
+// checkReferencedRows returns ErrForeignKeyViolation if some of the tuples are not found in the referenced table
+func checkReferencedRows(db *gorm.DB, c *schema.Constraint, tuples [][]interface{}) error {
+	if len(tuples) == 0 {
+		return nil
+	}
+
+	var count int64
+	err := db.Session(&gorm.Session{NewDB: true}).Table(c.ReferenceSchema.Table).
+		Where(inCondition(c.References, tuples)).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count < int64(len(tuples)) {
+		return fmt.Errorf("%w: %s of table %s references missing rows of table %s",
+			ErrForeignKeyViolation, c.Name, c.Schema.Table, c.ReferenceSchema.Table)
+	}
+	return nil
+}
+
+func inCondition(fields []*schema.Field, tuples [][]interface{}) clause.Expression {
+	if len(fields) == 1 {
+		values := make([]interface{}, len(tuples))
+		for i, tuple := range tuples {
+			values[i] = tuple[0]
+		}
+		return clause.IN{Column: clause.Column{Name: fields[0].DBName}, Values: values}
+	}
+
+	columns := make([]clause.Column, len(fields))
+	for i, field := range fields {
+		columns[i] = clause.Column{Name: field.DBName}
+	}
+	values := make([]interface{}, len(tuples))
+	for i, tuple := range tuples {
+		values[i] = tuple
+	}
+	return clause.IN{Column: columns, Values: values}
+}
+
+// tupleSet collects distinct tuples. Tuples with a nil or zero value reference nothing and are skipped.
+type tupleSet struct {
+	seen   map[string]bool
+	tuples [][]interface{}
+}
+
+func (s *tupleSet) add(tuple []interface{}) {
+	for i, v := range tuple {
+		rv := reflect.ValueOf(v)
+		for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			rv = rv.Elem()
+		}
+		if !rv.IsValid() || rv.IsZero() {
+			return
+		}
+		tuple[i] = rv.Interface()
+	}
+
+	key := fmt.Sprint(tuple...)
+	if s.seen == nil {
+		s.seen = map[string]bool{}
+	}
+	if !s.seen[key] {
+		s.seen[key] = true
+		s.tuples = append(s.tuples, tuple)
+	}
+}
+
+func structValues(ctx context.Context, rv reflect.Value, fields []*schema.Field) [][]interface{} {
+	var set tupleSet
+
+	add := func(elem reflect.Value) {
+		elem = reflect.Indirect(elem)
+		if elem.Kind() != reflect.Struct {
+			return
+		}
+		tuple := make([]interface{}, len(fields))
+		for i, field := range fields {
+			tuple[i], _ = field.ValueOf(ctx, elem)
+		}
+		set.add(tuple)
+	}
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			add(rv.Index(i))
+		}
+	default:
+		add(rv)
+	}
+	return set.tuples
+}
+
+func mapValues(values map[string]interface{}, fields []*schema.Field) ([]interface{}, bool) {
+	tuple := make([]interface{}, len(fields))
+	for i, field := range fields {
+		v, ok := values[field.Name]
+		if !ok {
+			if v, ok = values[field.DBName]; !ok {
+				return nil, false
+			}
+		}
+		tuple[i] = v
+	}
+
+	var set tupleSet
+	set.add(tuple)
+	if len(set.tuples) == 0 {
+		return nil, false
+	}
+	return set.tuples[0], true
+}
+
+func rowValues(rows []map[string]interface{}, fields []*schema.Field) [][]interface{} {
+	var set tupleSet
+	for _, row := range rows {
+		tuple := make([]interface{}, len(fields))
+		for i, field := range fields {
+			tuple[i] = row[field.DBName]
+		}
+		set.add(tuple)
+	}
+	return set.tuples
+}
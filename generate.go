@@ -0,0 +1,183 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"sort"
+	"strings"
+)
+
+// GenerateOptions configures the generation of models from the tables of an immudb database
+type GenerateOptions struct {
+	// Package is the package name of the generated file, models by default
+	Package string
+	// Tables restricts the generation to the given tables, all the tables by default
+	Tables []string
+}
+
+// GenerateModels returns the gofmt'd source of a gorm model for each table of the database.
+// Tables are sorted by name and fields follow the column order, so the output is deterministic.
+// immudb reports indexes per column, so multi column indexes are generated as single column ones.
+func GenerateModels(db *gorm.DB, opts *GenerateOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &GenerateOptions{}
+	}
+	pkg := opts.Package
+	if pkg == "" {
+		pkg = "models"
+	}
+
+	m, ok := db.Migrator().(Migrator)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNotImplemented, db.Migrator())
+	}
+
+	tables := opts.Tables
+	if len(tables) == 0 {
+		all, err := m.GetTables()
+		if err != nil {
+			return nil, err
+		}
+		for _, table := range all {
+			// bookkeeping tables of immugorm
+			if table != (SchemaMigration{}).TableName() && table != (TableVersion{}).TableName() {
+				tables = append(tables, table)
+			}
+		}
+	}
+	tables = append([]string(nil), tables...)
+	sort.Strings(tables)
+
+	var (
+		body    bytes.Buffer
+		useTime bool
+		names   = map[string]bool{}
+	)
+	for _, table := range tables {
+		columns, err := m.describeTable(table)
+		if err != nil {
+			return nil, fmt.Errorf("describing table %s: %w", table, err)
+		}
+		name := goIdentifier(schema.NamingStrategy{}.SchemaName(table), "Table", names)
+
+		var primaryKeys int
+		for _, c := range columns {
+			if isPrimaryKey, _ := c.PrimaryKey(); isPrimaryKey {
+				primaryKeys++
+			}
+		}
+
+		fmt.Fprintf(&body, "\ntype %s struct {\n", name)
+		fields := map[string]bool{}
+		for _, c := range columns {
+			goType, err := modelFieldType(c)
+			if err != nil {
+				return nil, fmt.Errorf("table %s: %w", table, err)
+			}
+			useTime = useTime || strings.HasSuffix(goType, "time.Time")
+			fieldName := goIdentifier(schema.NamingStrategy{SingularTable: true}.SchemaName(c.name), "Column", fields)
+			fmt.Fprintf(&body, "\t%s %s `gorm:\"%s\"`\n", fieldName, goType, modelFieldTag(c, primaryKeys))
+		}
+		fmt.Fprintf(&body, "}\n\nfunc (%s) TableName() string {\n\treturn %q\n}\n", name, table)
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by immugorm-gen. DO NOT EDIT.\n\npackage %s\n", pkg)
+	if useTime {
+		src.WriteString("\nimport \"time\"\n")
+	}
+	src.Write(body.Bytes())
+
+	return format.Source(src.Bytes())
+}
+
+// modelFieldType is the reverse of Dialector.DataTypeOf. Nullable columns are mapped to pointers, so NULL can be
+// scanned.
+func modelFieldType(c Column) (string, error) {
+	var goType string
+	switch c.datatype {
+	case "INTEGER":
+		goType = "int64"
+	case "BOOLEAN":
+		goType = "bool"
+	case "VARCHAR":
+		goType = "string"
+	case "BLOB":
+		return "[]byte", nil
+	case "TIMESTAMP":
+		goType = "time.Time"
+	default:
+		return "", fmt.Errorf("%w: column %s of type %s", ErrNotImplemented, c.name, c.datatype)
+	}
+	// immudb reports primary key columns as nullable, but they never hold NULL
+	isPrimaryKey, _ := c.PrimaryKey()
+	if nullable, _ := c.Nullable(); nullable && !isPrimaryKey {
+		goType = "*" + goType
+	}
+	return goType, nil
+}
+
+func modelFieldTag(c Column, primaryKeys int) string {
+	settings := []string{"column:" + c.name}
+
+	isPrimaryKey, _ := c.PrimaryKey()
+	if isPrimaryKey {
+		settings = append(settings, "primaryKey")
+	}
+	if c.autoIncrement {
+		settings = append(settings, "autoIncrement")
+	} else if isPrimaryKey && primaryKeys == 1 && c.datatype == "INTEGER" {
+		// gorm makes integer primary keys auto increment by default
+		settings = append(settings, "autoIncrement:false")
+	}
+	if length, ok := c.Length(); ok && length > 0 {
+		settings = append(settings, fmt.Sprintf("size:%d", length))
+	}
+	if nullable, _ := c.Nullable(); !nullable && !isPrimaryKey {
+		settings = append(settings, "not null")
+	}
+	if c.index == "YES" {
+		if c.unique {
+			settings = append(settings, "uniqueIndex")
+		} else {
+			settings = append(settings, "index")
+		}
+	}
+	return strings.Join(settings, ";")
+}
+
+// goIdentifier returns an exported identifier not already used, and records it
+func goIdentifier(name, prefix string, used map[string]bool) string {
+	if !token.IsIdentifier(name) || !token.IsExported(name) {
+		name = prefix + name
+	}
+	if !token.IsIdentifier(name) {
+		name = prefix
+	}
+	identifier := name
+	for i := 2; used[identifier]; i++ {
+		identifier = fmt.Sprintf("%s%d", name, i)
+	}
+	used[identifier] = true
+	return identifier
+}
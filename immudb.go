@@ -29,21 +29,52 @@ import (
 	"gorm.io/gorm/logger"
 	"gorm.io/gorm/migrator"
 	"gorm.io/gorm/schema"
+	"reflect"
 	"regexp"
+	"sync"
+	"time"
 )
 
 const DriverName = "immudb"
 
 type ImmuGormConfig struct {
 	Verify bool
+	// TableRebuild lets the migrator emulate DropColumn and AlterColumn by rebuilding the table
+	TableRebuild bool
+	// RebuildBatchSize is the number of rows copied in each transaction of a table rebuild
+	RebuildBatchSize int
+	// ForeignKeys enforces the constraints of the model relationships on the client side
+	ForeignKeys bool
+	// SurrogateKey adds a hidden auto increment primary key to the tables of models without a primary key
+	SurrogateKey bool
+	// TimeZone is the location of the times read from immudb, UTC if nil
+	TimeZone *time.Location
+	// KeyProvider provides the keys of the fields tagged with immudb:"encrypted"
+	KeyProvider KeyProvider
+	// JoinStrategy is the way the outer joins of relationships are run, emulated on the client by default
+	JoinStrategy JoinStrategy
+	// SortLimit is the number of rows sorted in memory when no index orders them like the query, 10000 if zero.
+	// Sorting on the client is disabled if negative.
+	SortLimit int
+	// SubqueryLimit is the number of values a subquery of a condition, run before the query, may return, 10000 if
+	// zero. Running the subqueries of conditions is disabled if negative.
+	SubqueryLimit int
+	// MaxTxEntries is the number of entries of a transaction, as set on the server, 1024 if zero. The rows created
+	// together are split into statements whose transactions fit in it.
+	MaxTxEntries int
 }
 
 type Dialector struct {
-	DriverName string
-	opts       *client.Options
-	cfg        *ImmuGormConfig
-	Conn       gorm.ConnPool
-	DSN        string
+	DriverName      string
+	opts            *client.Options
+	cfg             *ImmuGormConfig
+	Conn            gorm.ConnPool
+	DSN             string
+	tableVersions   sync.Map
+	foreignKeys     foreignKeys
+	preparedSchemas sync.Map
+	fieldCiphers    sync.Map
+	sqlDB           *sql.DB
 }
 
 func Open(dsn string, cfg *ImmuGormConfig) gorm.Dialector {
@@ -65,6 +96,47 @@ func OpenWithOptions(opts *client.Options, cfg *ImmuGormConfig) gorm.Dialector {
 	}
 }
 
+// UseDatabase opens a new connection on another database of the same immudb server,
+// with the same client options and configuration of db.
+func UseDatabase(db *gorm.DB, name string) (*gorm.DB, error) {
+	dialector, ok := db.Dialector.(*Dialector)
+	if !ok || dialector.opts == nil {
+		return nil, fmt.Errorf("no immuclient options available to switch to database %s", name)
+	}
+
+	opts := *dialector.opts
+	opts.Database = name
+
+	// the whole configuration is cloned, while the connection, the callbacks and the caches, like the parsed schemas
+	// the dialector prepares, belong to the new db
+	config := &gorm.Config{}
+	src, dst := reflect.ValueOf(db.Config).Elem(), reflect.ValueOf(config).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if dst.Field(i).CanSet() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	config.ConnPool = nil
+	config.Dialector = nil
+	config.Plugins = nil
+	config.ClauseBuilders = make(map[string]clause.ClauseBuilder, len(db.Config.ClauseBuilders))
+	for k, v := range db.Config.ClauseBuilders {
+		config.ClauseBuilders[k] = v
+	}
+
+	newDB, err := gorm.Open(OpenWithOptions(&opts, dialector.cfg), config)
+	if err != nil {
+		return nil, err
+	}
+	// plugins register their callbacks on the new db
+	for _, plugin := range db.Config.Plugins {
+		if err = newDB.Use(plugin); err != nil {
+			return nil, err
+		}
+	}
+	return newDB, nil
+}
+
 func (dialector *Dialector) Name() string {
 	return "immudb"
 }
@@ -74,12 +146,14 @@ func (dialector *Dialector) Initialize(db *gorm.DB) (err error) {
 		dialector.DriverName = DriverName
 	}
 
-	callbacks.RegisterDefaultCallbacks(db, &callbacks.Config{
+	callbacksConfig := &callbacks.Config{
 		CreateClauses: []string{"INSERT", "VALUES", "ON CONFLICT"},
 		UpdateClauses: []string{"UPDATE", "SET", "WHERE"},
 		DeleteClauses: []string{"DELETE", "FROM", "WHERE"},
 		QueryClauses:  []string{"SELECT", "FROM", "WHERE", "GROUP BY", "ORDER BY", "LIMIT" /*, "FOR"*/},
-	})
+	}
+	callbacks.RegisterDefaultCallbacks(db, callbacksConfig)
+	db.Callback().Create().Replace("gorm:create", dialector.createInBatches(dialector.create(callbacks.Create(callbacksConfig))))
 
 	var connStr = ""
 	if dialector.Conn != nil {
@@ -88,6 +162,9 @@ func (dialector *Dialector) Initialize(db *gorm.DB) (err error) {
 		connStr = stdlib.RegisterConnConfig(dialector.opts)
 	} else if dialector.DSN != "" {
 		connStr = dialector.DSN
+		if opts, err := stdlib.ParseConfig(dialector.DSN); err == nil {
+			dialector.opts = opts
+		}
 	} else {
 		return fmt.Errorf("no connection or immuclient options provided")
 	}
@@ -98,21 +175,79 @@ func (dialector *Dialector) Initialize(db *gorm.DB) (err error) {
 	}
 
 	db.ConnPool = conn
+	dialector.sqlDB = conn
 
 	for k, v := range dialector.ClauseBuilders() {
 		db.ClauseBuilders[k] = v
 	}
 
 	db.Config.SkipDefaultTransaction = true
+	nowFunc := db.Config.NowFunc
+	db.Config.NowFunc = func() time.Time {
+		return normalizeTime(nowFunc(), dialector.timeZone())
+	}
+
+	db.Callback().Create().Before("gorm:create").Register("immudb:default_values", dialector.setDefaultValues)
+	db.Callback().Create().Before("gorm:create").Register("immudb:uuid", dialector.generateUUIDs)
+	db.Callback().Create().Before("gorm:create").Register("immudb:normalize_times", dialector.normalizeTimes)
+	db.Callback().Update().Before("gorm:update").Register("immudb:normalize_times", dialector.normalizeTimes)
+	db.Callback().Query().After("gorm:query").Register("immudb:normalize_times", dialector.normalizeTimes)
+	db.Callback().Create().Before("gorm:create").Register("immudb:prepare_schema", dialector.prepareStatementSchema)
+	db.Callback().Query().Before("gorm:query").Register("immudb:prepare_schema", dialector.prepareStatementSchema)
+	db.Callback().Query().Before("gorm:query").Register("immudb:subqueries", dialector.inlineSubqueries)
+	db.Callback().Query().Before("gorm:query").Register("immudb:joins", dialector.emulateOuterJoins)
+	db.Callback().Query().Before("gorm:query").Register("immudb:group_by", dialector.groupBy)
+	db.Callback().Query().Before("gorm:query").Register("immudb:order_by", dialector.orderBy)
+	db.Callback().Query().Before("gorm:query").Register("immudb:offset", dialector.skipOffset)
+	db.Callback().Row().Before("gorm:row").Register("immudb:subqueries", dialector.inlineSubqueries)
+	db.Callback().Row().Before("gorm:row").Register("immudb:group_by", dialector.groupBy)
+	db.Callback().Row().Before("gorm:row").Register("immudb:order_by", dialector.orderBy)
+	db.Callback().Row().Before("gorm:row").Register("immudb:offset", dialector.skipOffset)
+	db.Callback().Row().After("gorm:row").Register("immudb:restore_conn_pool", dialector.restoreConnPool)
+	db.Callback().Update().Before("gorm:update").Register("immudb:prepare_schema", dialector.prepareStatementSchema)
+	db.Callback().Update().Before("gorm:update").Register("immudb:subqueries", dialector.inlineSubqueries)
+	db.Callback().Delete().Before("gorm:delete").Register("immudb:prepare_schema", dialector.prepareStatementSchema)
+	db.Callback().Delete().Before("gorm:delete").Register("immudb:subqueries", dialector.inlineSubqueries)
+	db.Callback().Create().Before("gorm:create").Register("immudb:lob", dialector.storeLobs)
+	db.Callback().Update().Before("gorm:update").Register("immudb:lob", dialector.storeLobs)
+	db.Callback().Create().Before("gorm:create").Register("immudb:serializer", dialector.serializeMapValues)
+	db.Callback().Update().Before("gorm:update").Register("immudb:serializer", dialector.serializeMapValues)
+
+	if dialector.cfg.TableRebuild {
+		if err = dialector.loadTableVersions(db); err != nil {
+			return
+		}
+		db.Callback().Create().Before("gorm:create").Register("immudb:table_version", dialector.useTableVersion)
+		db.Callback().Query().Before("gorm:query").Register("immudb:table_version", dialector.useTableVersion)
+		db.Callback().Update().Before("gorm:update").Register("immudb:table_version", dialector.useTableVersion)
+		db.Callback().Delete().Before("gorm:delete").Register("immudb:table_version", dialector.useTableVersion)
+		db.Callback().Row().Before("gorm:row").Register("immudb:table_version", dialector.useTableVersion)
+	}
+
+	if dialector.cfg.ForeignKeys {
+		db.Callback().Create().Before("gorm:create").Register("immudb:foreign_keys", dialector.checkForeignKeysOnCreate)
+		db.Callback().Update().Before("gorm:update").Register("immudb:foreign_keys", dialector.checkForeignKeysOnUpdate)
+		db.Callback().Delete().Before("gorm:delete").Register("immudb:foreign_keys", dialector.applyForeignKeysOnDelete)
+		db.Callback().Delete().After("gorm:delete").Register("immudb:foreign_keys_commit", dialector.commitForeignKeysOnDelete)
+	}
+
+	if dialector.cfg.SurrogateKey {
+		db.Callback().Update().Before("gorm:update").Register("immudb:surrogate_key", dialector.useSurrogateKeyOnUpdate)
+		db.Callback().Delete().Before("gorm:delete").Register("immudb:surrogate_key", dialector.useSurrogateKey)
+		db.Callback().Query().Before("gorm:query").Register("immudb:surrogate_key", dialector.orderBySurrogateKey)
+	}
 
 	if dialector.cfg.Verify {
 		db.Callback().Query().After("gorm:query").Register("immudb:after_query", dialector.verify)
 	}
+	// the rows computed on the client are verified before the pool is restored
+	db.Callback().Query().After("gorm:query").Register("immudb:restore_conn_pool", dialector.restoreConnPool)
 	return
 }
 
 func (dialector *Dialector) ClauseBuilders() map[string]clause.ClauseBuilder {
 	return map[string]clause.ClauseBuilder{
+		// the conflicting rows are updated by create, see upsert.go
 		"ON CONFLICT": func(c clause.Clause, builder clause.Builder) {
 			_, ok := c.Expression.(clause.OnConflict)
 			if !ok {
@@ -122,10 +257,20 @@ func (dialector *Dialector) ClauseBuilders() map[string]clause.ClauseBuilder {
 			builder.WriteString("ON CONFLICT DO NOTHING")
 			return
 		},
+		"VALUES":   buildValues,
+		"SET":      buildSet,
+		"WHERE":    buildWhere,
+		"FROM":     buildFrom,
+		"GROUP BY": buildGroupBy,
+		"ORDER BY": buildOrderBy,
+		"LIMIT":    buildLimit,
 	}
 }
 
 func (dialector *Dialector) DefaultValueOf(field *schema.Field) clause.Expression {
+	if field.DefaultValueInterface != nil {
+		return clause.Expr{SQL: "?", Vars: []interface{}{field.DefaultValueInterface}}
+	}
 	return nil
 }
 
@@ -141,9 +286,33 @@ func (dialector *Dialector) Migrator(db *gorm.DB) gorm.Migrator {
 }
 
 func (dialector *Dialector) BindVarTo(writer clause.Writer, stmt *gorm.Statement, v interface{}) {
+	if n := len(stmt.Vars); n > 0 {
+		stmt.AddError(checkIntegerVar(stmt.Vars[n-1]))
+		if encoded, ok := encodeFloatVar(stmt.Vars[n-1]); ok {
+			if !floatOperand(stmt, writtenSQL(writer)) {
+				stmt.AddError(fmt.Errorf("%w: %v is not assigned to or compared with a float column", ErrUnsupportedFloatParameter, stmt.Vars[n-1]))
+			}
+			stmt.Vars[n-1] = encoded
+		} else if encoded, ok := encodeUUIDVar(stmt.Vars[n-1]); ok {
+			stmt.Vars[n-1] = encoded
+		} else if normalized, ok := normalizeTimeVar(stmt.Vars[n-1]); ok {
+			stmt.Vars[n-1] = normalized
+		}
+	}
 	writer.WriteByte('?')
 }
 
+// writtenSQL returns the SQL written so far by the builder of a statement
+func writtenSQL(writer clause.Writer) string {
+	switch w := writer.(type) {
+	case *gorm.Statement:
+		return w.SQL.String()
+	case fmt.Stringer:
+		return w.String()
+	}
+	return ""
+}
+
 func (dialector *Dialector) QuoteTo(writer clause.Writer, str string) {
 	writer.WriteString(str)
 	return
@@ -156,7 +325,25 @@ func (dialector *Dialector) Explain(sql string, vars ...interface{}) string {
 }
 
 func (dialector *Dialector) DataTypeOf(field *schema.Field) string {
-	switch field.DataType {
+	dataType := field.DataType
+	if _, ok := field.TagSettings["TYPE"]; !ok && field.Serializer != nil {
+		dataType = serializedDataType(field)
+	}
+
+	if isEncryptedField(field) {
+		// see fieldCipher
+		if size := encryptedColumnSize(field); size > 0 {
+			return fmt.Sprintf("BLOB[%d]", size)
+		}
+		return "BLOB"
+	}
+
+	if isUUIDField(field) {
+		// see encodeUUIDVar
+		return fmt.Sprintf("BLOB[%d]", uuidSize)
+	}
+
+	switch dataType {
 	case schema.Bool:
 		return "BOOLEAN"
 	case schema.Int, schema.Uint:
@@ -177,6 +364,16 @@ func (dialector *Dialector) DataTypeOf(field *schema.Field) string {
 		return "BLOB"
 	case schema.Time:
 		return "TIMESTAMP"
+	case schema.Float:
+		// see encodeFloat
+		return "INTEGER"
+	case "decimal":
+		return fmt.Sprintf("VARCHAR[%d]", decimalColumnSize)
+	case "uint64":
+		return fmt.Sprintf("BLOB[%d]", uint64ColumnSize)
+	case LobDataType:
+		// see lobReference
+		return fmt.Sprintf("VARCHAR[%d]", lobReferenceSize)
 	}
 
 	return string(field.DataType)
@@ -207,6 +404,10 @@ func executeOnImmuClient(db *gorm.DB, f func(client.ImmuClient) error) error {
 	if err != nil {
 		return err
 	}
+	return executeOnDB(sqlDb, f)
+}
+
+func executeOnDB(sqlDb *sql.DB, f func(client.ImmuClient) error) error {
 	conn, err := sqlDb.Conn(context.TODO())
 	if err != nil {
 		return err
@@ -216,5 +417,5 @@ func executeOnImmuClient(db *gorm.DB, f func(client.ImmuClient) error) error {
 		return f(ic)
 	})
 	conn.Close()
-	return nil
+	return err
 }
@@ -0,0 +1,87 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// immudb INTEGER columns hold signed 64 bit integers. Unsigned values above math.MaxInt64 are rejected on write
+// with ErrIntegerOverflow instead of wrapping around; fields needing the full uint64 range use Uint64.
+
+// checkIntegerVar returns ErrIntegerOverflow if v is an unsigned integer that does not fit in an INTEGER column
+func checkIntegerVar(v interface{}) error {
+	if _, ok := v.(driver.Valuer); ok {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return fmt.Errorf("%w: %d does not fit in a signed 64 bit INTEGER column", ErrIntegerOverflow, rv.Uint())
+		}
+	}
+	return nil
+}
+
+// uint64ColumnSize is the size of the BLOB column holding a Uint64
+const uint64ColumnSize = 8
+
+// Uint64 is an unsigned 64 bit integer covering the whole uint64 range. It is stored in a BLOB column holding its
+// big endian bytes, whose order is the numeric order, so comparisons and ORDER BY work in queries, provided the
+// parameters are Uint64 values too.
+type Uint64 uint64
+
+func (u Uint64) Value() (driver.Value, error) {
+	b := make([]byte, uint64ColumnSize)
+	binary.BigEndian.PutUint64(b, uint64(u))
+	return b, nil
+}
+
+func (u *Uint64) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		if len(v) != uint64ColumnSize {
+			return fmt.Errorf("%w: invalid value of %d bytes for Uint64", ErrIntegerOverflow, len(v))
+		}
+		*u = Uint64(binary.BigEndian.Uint64(v))
+	case int64:
+		if v < 0 {
+			return fmt.Errorf("%w: negative value %d for Uint64", ErrIntegerOverflow, v)
+		}
+		*u = Uint64(v)
+	case nil:
+		*u = 0
+	default:
+		return fmt.Errorf("unsupported value %v of type %T for Uint64", src, src)
+	}
+	return nil
+}
+
+func (Uint64) GormDataType() string {
+	return "uint64"
+}
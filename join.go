@@ -0,0 +1,184 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+// JoinStrategy is the way the outer joins of relationships, added with Joins, are run
+type JoinStrategy int
+
+const (
+	// EmulatedJoins runs the outer joins of relationships on the client, as immudb only supports inner joins.
+	// The records are read without the join and the related records are merged into them like with Preload, leaving
+	// the relationships without a matching record zero. Joins referenced by the conditions of the query are also sent
+	// as inner joins, to filter the records, when the conditions reject the rows without a matching record.
+	EmulatedJoins JoinStrategy = iota
+	// ServerJoins sends the outer joins to the server, for immudb versions supporting them
+	ServerJoins
+)
+
+// innerJoinsSetting holds the aliases of the joins sent to the server as inner joins
+const innerJoinsSetting = "immudb:inner_joins"
+
+// emulateOuterJoins replaces the joins of the belongs to and has one relationships of the query with preloads
+func (dialector *Dialector) emulateOuterJoins(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || len(stmt.Joins) == 0 || stmt.SQL.Len() > 0 || !stmt.ReflectValue.IsValid() ||
+		(dialector.cfg != nil && dialector.cfg.JoinStrategy == ServerJoins) {
+		return
+	}
+	if _, ok := stmt.Settings.Load(serverOnlySetting); ok {
+		// the joins of subqueries read as tables are sent as they are
+		return
+	}
+
+	// the relationships are only merged into records of the model, not into counts or plucked values
+	modelType := stmt.ReflectValue.Type()
+	if modelType.Kind() == reflect.Slice || modelType.Kind() == reflect.Array {
+		modelType = modelType.Elem()
+	}
+	for modelType.Kind() == reflect.Ptr {
+		modelType = modelType.Elem()
+	}
+	preload := modelType == stmt.Schema.ModelType
+
+	referenced := referencedTables(db)
+	conditions := clausesSQL(db, "WHERE")
+	conditioned := tablesIn(conditions)
+	joins := stmt.Joins[:0:0]
+	innerJoins := map[string]bool{}
+	for _, join := range stmt.Joins {
+		rel, ok := stmt.Schema.Relationships.Relations[join.Name]
+		if !ok || (rel.Type != schema.BelongsTo && rel.Type != schema.HasOne) {
+			joins = append(joins, join)
+			continue
+		}
+
+		if _, ok := stmt.Preloads[join.Name]; !ok && preload {
+			var conds []interface{}
+			if join.On != nil {
+				on := *join.On
+				conds = append(conds, func(tx *gorm.DB) *gorm.DB {
+					return tx.Where(on)
+				})
+			}
+			if stmt.Preloads == nil {
+				stmt.Preloads = map[string][]interface{}{}
+			}
+			stmt.Preloads[join.Name] = conds
+		}
+
+		// rows without a matching record do not satisfy conditions on its columns, which are not NULL, unless the
+		// conditions test NULL values or apply to other rows too
+		if name := strings.ToLower(join.Name); referenced[name] {
+			if !conditioned[name] || !rejectsNull(conditions) {
+				db.AddError(fmt.Errorf("%w: the left join of %s cannot be emulated as the query keeps records without a related one, use Preload or ServerJoins", ErrUnsupportedJoin, join.Name))
+				return
+			}
+			joins = append(joins, join)
+			innerJoins[join.Name] = true
+		}
+	}
+	stmt.Joins = joins
+	if len(innerJoins) > 0 {
+		stmt.Settings.Store(innerJoinsSetting, innerJoins)
+	}
+}
+
+// referencedTables returns the lower case names of the tables referenced by the conditions, the grouping and the
+// order of the query. immudb folds the case of names.
+func referencedTables(db *gorm.DB) map[string]bool {
+	return tablesIn(clausesSQL(db, "WHERE", "GROUP BY", "ORDER BY"))
+}
+
+// clausesSQL returns the lower case SQL of the clauses of the query, without their values
+func clausesSQL(db *gorm.DB, clauses ...string) string {
+	tx := db.Session(&gorm.Session{NewDB: true})
+	stmt := &gorm.Statement{DB: tx, Table: db.Statement.Table, Schema: db.Statement.Schema, Clauses: db.Statement.Clauses}
+	stmt.Build(clauses...)
+	return strings.ToLower(stmt.SQL.String())
+}
+
+// tablesIn returns the names of the tables qualifying the columns of sql
+func tablesIn(sql string) map[string]bool {
+	tables := map[string]bool{}
+	for i := strings.IndexByte(sql, '.'); i >= 0; i = strings.IndexByte(sql, '.') {
+		start := i
+		for start > 0 && isNameByte(sql[start-1]) {
+			start--
+		}
+		tables[sql[start:i]] = true
+		sql = sql[i+1:]
+	}
+	return tables
+}
+
+// rejectsNull reports whether conditions, a conjunction of comparisons, reject the rows whose compared columns are
+// NULL. Conditions with OR, NULL tests or the functions replacing NULL may keep them.
+func rejectsNull(conditions string) bool {
+	words := strings.FieldsFunc(conditions, func(r rune) bool { return r > 0x7f || !isNameByte(byte(r)) })
+	for i, word := range words {
+		switch word {
+		case "or", "coalesce", "ifnull", "nullif", "case":
+			return false
+		case "null":
+			if i > 0 && words[i-1] == "is" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isNameByte(b byte) bool {
+	return b == '_' || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9')
+}
+
+// buildFrom sends the outer joins of relationships referenced by the query as inner joins, see emulateOuterJoins,
+// and reads the table with the index ordering the rows, see orderBy
+func buildFrom(c clause.Clause, builder clause.Builder) {
+	from, ok := c.Expression.(clause.From)
+	stmt, isStmt := builder.(*gorm.Statement)
+	if !ok || !isStmt {
+		c.Build(builder)
+		return
+	}
+
+	if v, ok := stmt.Settings.Load(innerJoinsSetting); ok {
+		innerJoins := v.(map[string]bool)
+		joins := make([]clause.Join, len(from.Joins))
+		for idx, join := range from.Joins {
+			if join.Type == clause.LeftJoin && innerJoins[join.Table.Alias] {
+				join.Type = clause.InnerJoin
+			}
+			joins[idx] = join
+		}
+		from.Joins = joins
+	}
+	c.Expression = from
+	if v, ok := stmt.Settings.Load(useIndexSetting); ok {
+		c.Expression = indexedFrom{From: from, index: v.(sortingIndex).columns}
+	}
+	c.Build(builder)
+}
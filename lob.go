@@ -0,0 +1,394 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"github.com/codenotary/immudb/pkg/client"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Values of large object fields, tagged with type:immudb_lob or of type LargeObject, are split into chunks stored in
+// the key value store of immudb. The column holds a reference to them: the SHA-256 hash and the size of the value.
+// Chunks are content addressed, a value already stored is not written again. They are read and written with
+// verified operations when the Verify option is set, and the hash of the loaded value is always checked.
+
+const (
+	// LobDataType is the gorm data type of large object fields
+	LobDataType schema.DataType = "immudb_lob"
+	// LobChunkSize is the size of the chunks large objects are split into
+	LobChunkSize = 1 << 20
+	// lobReferenceSize is the size of the VARCHAR column holding the reference to a large object
+	lobReferenceSize = 128
+	lobKeyPrefix     = "_immugorm.lob."
+)
+
+// lobReference identifies the chunks of a large object
+type lobReference struct {
+	hash string
+	size int64
+}
+
+func newLobReference(data []byte) lobReference {
+	sum := sha256.Sum256(data)
+	return lobReference{hash: hex.EncodeToString(sum[:]), size: int64(len(data))}
+}
+
+func parseLobReference(s string) (lobReference, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 || len(parts[0]) != sha256.Size*2 {
+		return lobReference{}, fmt.Errorf("%w: invalid large object reference %q", ErrCorruptedData, s)
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return lobReference{}, fmt.Errorf("%w: invalid large object reference %q", ErrCorruptedData, s)
+	}
+	return lobReference{hash: parts[0], size: size}, nil
+}
+
+func (r lobReference) String() string {
+	return r.hash + ":" + strconv.FormatInt(r.size, 10)
+}
+
+func (r lobReference) chunks() int {
+	return int((r.size + LobChunkSize - 1) / LobChunkSize)
+}
+
+func (r lobReference) chunkKey(i int) []byte {
+	return []byte(lobKeyPrefix + r.hash + "." + strconv.Itoa(i))
+}
+
+// storeLob writes the chunks of data, unless the last one is already stored. The chunks cannot be written inside
+// a transaction: the key value store is not part of it and the transaction would conflict with their writes.
+func storeLob(ctx context.Context, ic client.ImmuClient, data []byte, verify, inTransaction bool) error {
+	ref := newLobReference(data)
+	n := ref.chunks()
+	if n == 0 {
+		return nil
+	}
+	if _, err := ic.Get(ctx, ref.chunkKey(n-1)); err == nil {
+		return nil
+	}
+	if inTransaction {
+		return fmt.Errorf("%w: %s", ErrLobInTransaction, ref.hash)
+	}
+
+	for i := 0; i < n; i++ {
+		end := (i + 1) * LobChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[i*LobChunkSize : end]
+		var err error
+		if verify {
+			_, err = ic.VerifiedSet(ctx, ref.chunkKey(i), chunk)
+		} else {
+			_, err = ic.Set(ctx, ref.chunkKey(i), chunk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadLob reads the chunks of a large object and checks the hash of the value
+func loadLob(ctx context.Context, ic client.ImmuClient, ref lobReference, verify bool) ([]byte, error) {
+	data := make([]byte, 0, ref.size)
+	for i := 0; i < ref.chunks(); i++ {
+		get := ic.Get
+		if verify {
+			get = ic.VerifiedGet
+		}
+		entry, err := get(ctx, ref.chunkKey(i))
+		if err != nil {
+			if err.Error() == "data is corrupted" {
+				return nil, ErrCorruptedData
+			}
+			return nil, err
+		}
+		data = append(data, entry.Value...)
+	}
+	if newLobReference(data) != ref {
+		return nil, fmt.Errorf("%w: large object %s does not match its hash", ErrCorruptedData, ref.hash)
+	}
+	return data, nil
+}
+
+// LargeObject is a large object loaded lazily: values read from the database hold the reference to the chunks,
+// which are loaded by Load.
+type LargeObject struct {
+	ref    lobReference
+	data   []byte
+	loaded bool
+}
+
+// NewLargeObject returns a large object holding data
+func NewLargeObject(data []byte) LargeObject {
+	return LargeObject{ref: newLobReference(data), data: data, loaded: true}
+}
+
+// Hash returns the hex encoded SHA-256 hash of the value
+func (o LargeObject) Hash() string {
+	return o.ref.hash
+}
+
+// Size returns the size of the value in bytes
+func (o LargeObject) Size() int64 {
+	return o.ref.size
+}
+
+// Load returns the value, reading its chunks the first time. The zero LargeObject, stored as NULL, has no value.
+func (o *LargeObject) Load(db *gorm.DB) ([]byte, error) {
+	if o.loaded || o.ref.hash == "" {
+		return o.data, nil
+	}
+	dialector, ok := db.Dialector.(*Dialector)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNotImplemented, db.Dialector)
+	}
+	err := executeOnDB(dialector.sqlDB, func(ic client.ImmuClient) (err error) {
+		o.data, err = loadLob(db.Statement.Context, ic, o.ref, dialector.verifyEnabled())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	o.loaded = true
+	return o.data, nil
+}
+
+func (o LargeObject) Value() (driver.Value, error) {
+	if o.ref.hash == "" {
+		return nil, nil
+	}
+	return o.ref.String(), nil
+}
+
+func (o *LargeObject) Scan(src interface{}) (err error) {
+	switch v := src.(type) {
+	case string:
+		o.ref, err = parseLobReference(v)
+	case []byte:
+		o.ref, err = parseLobReference(string(v))
+	case nil:
+		*o = LargeObject{}
+		return nil
+	default:
+		return fmt.Errorf("unsupported value %v of type %T for a large object", src, src)
+	}
+	o.data, o.loaded = nil, o.ref.size == 0
+	return err
+}
+
+func (LargeObject) GormDataType() string {
+	return string(LobDataType)
+}
+
+// lobValue is the value sent to immudb for the large object fields holding their value
+type lobValue struct {
+	ref lobReference
+}
+
+func (v lobValue) Value() (driver.Value, error) {
+	return v.ref.String(), nil
+}
+
+// lobColumn scans the reference held by a large object column
+type lobColumn struct {
+	ref   lobReference
+	valid bool
+}
+
+func (c *lobColumn) Scan(src interface{}) (err error) {
+	switch v := src.(type) {
+	case string:
+		c.ref, err = parseLobReference(v)
+	case []byte:
+		c.ref, err = parseLobReference(string(v))
+	case nil:
+		c.ref = lobReference{}
+	default:
+		return fmt.Errorf("unsupported value %v of type %T for a large object column", src, src)
+	}
+	c.valid = src != nil
+	return err
+}
+
+var lobColumnPool = &sync.Pool{
+	New: func() interface{} {
+		return new(lobColumn)
+	},
+}
+
+func isLobField(field *schema.Field) bool {
+	return field.DataType == LobDataType
+}
+
+func (dialector *Dialector) verifyEnabled() bool {
+	return dialector.cfg != nil && dialector.cfg.Verify
+}
+
+// prepareLobField makes the field hold the value of the large object: the reference is sent in place of the value,
+// and the value is loaded eagerly when the reference is scanned. LargeObject fields are left as they are.
+func (dialector *Dialector) prepareLobField(field *schema.Field) {
+	if reflect.PtrTo(field.IndirectFieldType).Implements(scannerType) {
+		return
+	}
+	switch field.IndirectFieldType.Kind() {
+	case reflect.String:
+	case reflect.Slice:
+		if field.IndirectFieldType.Elem().Kind() != reflect.Uint8 {
+			return
+		}
+	default:
+		return
+	}
+
+	valueOf := field.ValueOf
+	field.ValueOf = func(ctx context.Context, rv reflect.Value) (interface{}, bool) {
+		value, zero := valueOf(ctx, rv)
+		if data, ok := lobData(value); ok {
+			return lobValue{ref: newLobReference(data)}, zero
+		}
+		return nil, zero
+	}
+
+	set := field.Set
+	field.Set = func(ctx context.Context, rv reflect.Value, v interface{}) error {
+		if _, ok := v.(lobValue); ok {
+			// assigned by gorm after a map update, the field already holds the value
+			return nil
+		}
+		column, ok := v.(*lobColumn)
+		if !ok {
+			return set(ctx, rv, v)
+		}
+		if !column.valid {
+			return set(ctx, rv, nil)
+		}
+		var data []byte
+		err := executeOnDB(dialector.sqlDB, func(ic client.ImmuClient) (err error) {
+			data, err = loadLob(ctx, ic, column.ref, dialector.verifyEnabled())
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if field.IndirectFieldType.Kind() == reflect.String {
+			return set(ctx, rv, string(data))
+		}
+		return set(ctx, rv, data)
+	}
+	field.NewValuePool = lobColumnPool
+}
+
+// lobData returns the bytes of the value of a large object field, false for NULL values
+func lobData(value interface{}) ([]byte, bool) {
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		return []byte(rv.String()), true
+	case reflect.Slice:
+		if rv.IsNil() || rv.Type().Elem().Kind() != reflect.Uint8 {
+			return nil, false
+		}
+		return rv.Bytes(), true
+	}
+	return nil, false
+}
+
+// storeLobs writes the chunks of the large objects of the created and updated records before the statement
+// references them
+func (dialector *Dialector) storeLobs(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || db.DryRun {
+		return
+	}
+
+	var lobs [][]byte
+	collect := func(value interface{}) {
+		switch v := value.(type) {
+		case LargeObject:
+			if v.loaded {
+				lobs = append(lobs, v.data)
+			}
+		case *LargeObject:
+			if v != nil && v.loaded {
+				lobs = append(lobs, v.data)
+			}
+		default:
+			if data, ok := lobData(value); ok {
+				lobs = append(lobs, data)
+			}
+		}
+	}
+
+	for _, field := range db.Statement.Schema.Fields {
+		if !isLobField(field) {
+			continue
+		}
+		switch dest := db.Statement.Dest.(type) {
+		case map[string]interface{}:
+			if v, ok := dest[field.Name]; ok {
+				collect(v)
+			} else if v, ok := dest[field.DBName]; ok {
+				collect(v)
+			}
+			continue
+		}
+
+		switch db.Statement.ReflectValue.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < db.Statement.ReflectValue.Len(); i++ {
+				if rv := reflect.Indirect(db.Statement.ReflectValue.Index(i)); rv.Kind() == reflect.Struct {
+					collect(field.ReflectValueOf(db.Statement.Context, rv).Interface())
+				}
+			}
+		case reflect.Struct:
+			collect(field.ReflectValueOf(db.Statement.Context, db.Statement.ReflectValue).Interface())
+		}
+	}
+	if len(lobs) == 0 {
+		return
+	}
+
+	// the connection of a transaction cannot be shared, another connection of the pool is used
+	_, inTransaction := db.Statement.ConnPool.(gorm.TxCommitter)
+	db.AddError(executeOnDB(dialector.sqlDB, func(ic client.ImmuClient) error {
+		for _, data := range lobs {
+			if err := storeLob(db.Statement.Context, ic, data, dialector.verifyEnabled(), inTransaction); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
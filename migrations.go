@@ -0,0 +1,228 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"context"
+	"fmt"
+	"github.com/codenotary/immudb/pkg/api/schema"
+	"github.com/codenotary/immudb/pkg/client"
+	"gorm.io/gorm"
+	"sort"
+	"time"
+)
+
+// Migration is a named schema change step. Migrations are applied in the order they are provided to the runner.
+type Migration struct {
+	ID      string
+	Migrate func(db *gorm.DB) error
+	// NoTransaction runs the migration outside of a transaction, for the changes immudb cannot make in one, like
+	// table rebuilds or large objects. The migration is recorded as pending before it runs, see Resolve.
+	NoTransaction bool
+}
+
+// SchemaMigration is the record stored in the schema_migrations table for every applied migration.
+// Since immudb never forgets, the table is an auditable change log of the schema.
+type SchemaMigration struct {
+	ID  string `gorm:"primaryKey;size:255"`
+	Seq int
+	// TxID is the id of the transaction that recorded the migration as applied, or as pending, which immudb keeps in
+	// the history of the record
+	TxID uint64 `gorm:"-"`
+	// AppliedAt is zero while a migration run outside of a transaction is pending
+	AppliedAt time.Time
+}
+
+// IsPending reports whether the migration was interrupted before being recorded as applied
+func (m SchemaMigration) IsPending() bool {
+	return m.AppliedAt.IsZero()
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+type MigrationOptions struct {
+	// DryRun reports the pending migrations without applying them
+	DryRun bool
+}
+
+type MigrationRunner struct {
+	db         *gorm.DB
+	migrations []*Migration
+	opts       *MigrationOptions
+}
+
+func NewMigrationRunner(db *gorm.DB, migrations []*Migration, opts *MigrationOptions) *MigrationRunner {
+	if opts == nil {
+		opts = &MigrationOptions{}
+	}
+	return &MigrationRunner{
+		db:         db,
+		migrations: migrations,
+		opts:       opts,
+	}
+}
+
+// Applied returns the migrations recorded in the schema_migrations table, in the order they were applied.
+func (r *MigrationRunner) Applied() ([]SchemaMigration, error) {
+	if !r.db.Migrator().HasTable(&SchemaMigration{}) {
+		return nil, nil
+	}
+
+	var records []SchemaMigration
+	if err := r.db.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Seq < records[j].Seq
+	})
+	if err := r.readTxIDs(records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// readTxIDs sets the ids of the transactions that last wrote the records
+func (r *MigrationRunner) readTxIDs(records []SchemaMigration) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return executeOnImmuClient(r.db, func(ic client.ImmuClient) error {
+		ctx := context.Background()
+		state, err := ic.CurrentState(ctx)
+		if err != nil {
+			return err
+		}
+		for i := range records {
+			entry, err := ic.GetServiceClient().VerifiableSQLGet(ctx, &schema.VerifiableSQLGetRequest{
+				SqlGetRequest: &schema.SQLGetRequest{
+					Table:    SchemaMigration{}.TableName(),
+					PkValues: []*schema.SQLValue{{Value: &schema.SQLValue_S{S: records[i].ID}}},
+				},
+				ProveSinceTx: state.TxId,
+			})
+			if err != nil {
+				return err
+			}
+			records[i].TxID = entry.SqlEntry.Tx
+		}
+		return nil
+	})
+}
+
+// Pending checks the recorded history against the provided migrations and returns the ones not applied yet.
+func (r *MigrationRunner) Pending() ([]*Migration, error) {
+	seen := make(map[string]bool, len(r.migrations))
+	for _, m := range r.migrations {
+		if m.ID == "" || seen[m.ID] {
+			return nil, fmt.Errorf("%w: invalid or duplicated migration id %q", ErrInvalidMigration, m.ID)
+		}
+		seen[m.ID] = true
+	}
+
+	applied, err := r.Applied()
+	if err != nil {
+		return nil, err
+	}
+	if len(applied) > len(r.migrations) {
+		return nil, fmt.Errorf("%w: %d migrations applied, %d known", ErrMigrationHistoryMismatch, len(applied), len(r.migrations))
+	}
+	for i, record := range applied {
+		if record.ID != r.migrations[i].ID || record.Seq != i+1 {
+			return nil, fmt.Errorf("%w: expected %q at position %d, found %q", ErrMigrationHistoryMismatch, r.migrations[i].ID, i+1, record.ID)
+		}
+		if record.IsPending() {
+			return nil, fmt.Errorf("%w: %q may be partially applied, check the schema and call Resolve", ErrMigrationInterrupted, record.ID)
+		}
+	}
+	return r.migrations[len(applied):], nil
+}
+
+// Migrate applies the pending migrations in order and records each one in the transaction applying it.
+// It returns the ids of the migrations applied, or the ones that would be applied when running in dry-run mode.
+func (r *MigrationRunner) Migrate() ([]string, error) {
+	pending, err := r.Pending()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(pending))
+	if r.opts.DryRun {
+		for _, m := range pending {
+			ids = append(ids, m.ID)
+		}
+		return ids, nil
+	}
+
+	if err = r.db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+
+	seq := len(r.migrations) - len(pending)
+	for _, m := range pending {
+		seq++
+		if m.NoTransaction {
+			err = r.migrateWithoutTransaction(m, seq)
+		} else {
+			// the schema changes and the record are committed together
+			err = r.db.Transaction(func(tx *gorm.DB) error {
+				if m.Migrate != nil {
+					if err := m.Migrate(tx); err != nil {
+						return fmt.Errorf("migration %q failed: %w", m.ID, err)
+					}
+				}
+				return tx.Create(&SchemaMigration{ID: m.ID, Seq: seq, AppliedAt: time.Now()}).Error
+			})
+		}
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, m.ID)
+	}
+	return ids, nil
+}
+
+// migrateWithoutTransaction records the migration as pending, runs it and records it as applied. A crash in between
+// leaves the pending record, which stops the following runs until Resolve is called.
+func (r *MigrationRunner) migrateWithoutTransaction(m *Migration, seq int) error {
+	if err := r.db.Create(&SchemaMigration{ID: m.ID, Seq: seq}).Error; err != nil {
+		return err
+	}
+	if m.Migrate != nil {
+		if err := m.Migrate(r.db); err != nil {
+			return fmt.Errorf("migration %q failed: %w", m.ID, err)
+		}
+	}
+	return r.db.Model(&SchemaMigration{ID: m.ID}).Update("applied_at", time.Now()).Error
+}
+
+// Resolve settles a migration interrupted while running outside of a transaction, once its changes have been checked:
+// it is recorded as applied if they were all made, or its record is deleted so that the next run applies it again.
+func (r *MigrationRunner) Resolve(id string, applied bool) error {
+	var record SchemaMigration
+	if err := r.db.Where("id = ?", id).First(&record).Error; err != nil {
+		return err
+	}
+	if !record.IsPending() {
+		return fmt.Errorf("%w: %q is not pending", ErrInvalidMigration, id)
+	}
+	if !applied {
+		return r.db.Delete(&record).Error
+	}
+	return r.db.Model(&record).Update("applied_at", time.Now()).Error
+}
@@ -21,18 +21,37 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	immuschema "github.com/codenotary/immudb/pkg/api/schema"
 	"github.com/codenotary/immudb/pkg/client"
 	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"gorm.io/gorm/migrator"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// maxIndexedColumnSize is the maximum size of VARCHAR and BLOB columns that can be indexed
+const maxIndexedColumnSize = 256
+
 type Migrator struct {
 	migrator.Migrator
 }
 
+// RunWithValue prepares the schema of value for immudb before running fc
+func (m Migrator) RunWithValue(value interface{}, fc func(*gorm.Statement) error) error {
+	return m.Migrator.RunWithValue(value, func(stmt *gorm.Statement) error {
+		if dialector, ok := m.Dialector.(*Dialector); ok {
+			dialector.prepareSchema(stmt.Schema)
+		}
+		return fc(stmt)
+	})
+}
+
 func (m Migrator) CreateTable(values ...interface{}) error {
 	for _, value := range m.ReorderModels(values, false) {
 		tx := m.DB.Session(&gorm.Session{})
@@ -53,9 +72,47 @@ func (m Migrator) CreateTable(values ...interface{}) error {
 				}
 			}
 
-			if !hasPrimaryKeyInDataType && len(stmt.Schema.PrimaryFields) > 0 {
+			if m.hasSurrogateKey(stmt) {
+				createTableSQL += "? INTEGER AUTO_INCREMENT,PRIMARY KEY ?,"
+				values = append(values, clause.Column{Name: SurrogateKeyColumn}, clause.Column{Name: SurrogateKeyColumn})
+			} else if !hasPrimaryKeyInDataType && len(stmt.Schema.PrimaryFields) > 0 {
 				createTableSQL += "PRIMARY KEY ?,"
-				values = append(values, clause.Column{Name: stmt.Schema.PrimaryFields[0].DBName})
+				primaryKeys := []interface{}{}
+				for _, field := range stmt.Schema.PrimaryFields {
+					primaryKeys = append(primaryKeys, clause.Column{Name: field.DBName})
+				}
+
+				values = append(values, primaryKeys)
+			}
+
+			if errr = m.checkIndexedFieldSizes(stmt, stmt.Schema.PrimaryFields); errr != nil {
+				return errr
+			}
+
+			uniqueIndexes := map[string]bool{}
+			for _, idx := range stmt.Schema.ParseIndexes() {
+				if errr = m.checkIndexedFieldSizes(stmt, indexFields(idx)); errr != nil {
+					return errr
+				}
+				if idx.Class == "UNIQUE" && len(idx.Fields) == 1 {
+					uniqueIndexes[idx.Fields[0].DBName] = true
+				}
+			}
+
+			// immudb has no UNIQUE column constraint, unique fields get a unique index
+			for _, dbName := range stmt.Schema.DBNames {
+				field := stmt.Schema.FieldsByDBName[dbName]
+				if !field.Unique || field.IgnoreMigration || uniqueIndexes[dbName] || (field.PrimaryKey && len(stmt.Schema.PrimaryFields) == 1) {
+					continue
+				}
+				if errr = m.checkIndexedFieldSizes(stmt, []*schema.Field{field}); errr != nil {
+					return errr
+				}
+				defer func(field *schema.Field) {
+					if errr == nil {
+						errr = tx.Exec("CREATE UNIQUE INDEX ON ? (?)", m.CurrentTable(stmt), clause.Column{Name: field.DBName}).Error
+					}
+				}(field)
 			}
 
 			for _, idx := range stmt.Schema.ParseIndexes() {
@@ -97,11 +154,57 @@ func (m Migrator) CreateTable(values ...interface{}) error {
 	return nil
 }
 
+// FullDataTypeOf returns the column definition in the form accepted by immudb: TYPE[size] [NOT NULL] [AUTO_INCREMENT].
+// UNIQUE is emitted as a unique index by CreateTable, and defaults are filled on the client side.
+func (m Migrator) FullDataTypeOf(field *schema.Field) clause.Expr {
+	dataType := m.DataTypeOf(field)
+	autoIncrement := strings.HasSuffix(dataType, " AUTO_INCREMENT")
+	expr := clause.Expr{SQL: strings.TrimSuffix(dataType, " AUTO_INCREMENT")}
+
+	if field.NotNull {
+		expr.SQL += " NOT NULL"
+	}
+	if autoIncrement {
+		expr.SQL += " AUTO_INCREMENT"
+	}
+	return expr
+}
+
+// checkIndexedFieldSizes makes sure VARCHAR and BLOB columns used in an index have a size immudb is able to index
+func (m Migrator) checkIndexedFieldSizes(stmt *gorm.Statement, fields []*schema.Field) error {
+	for _, field := range fields {
+		if (field.DataType != schema.String && field.DataType != schema.Bytes) || isUUIDField(field) {
+			continue
+		}
+		if isEncryptedField(field) {
+			// the ciphertext is longer than the value
+			if size := encryptedColumnSize(field); size <= 0 || size > maxIndexedColumnSize {
+				return fmt.Errorf("%w: encrypted column %s of table %s is indexed and needs a size between 1 and %d, got %d",
+					ErrInvalidIndexedColumnSize, field.DBName, stmt.Table, maxIndexedColumnSize-encryptionOverhead, field.Size)
+			}
+			continue
+		}
+		if field.Size <= 0 || field.Size > maxIndexedColumnSize {
+			return fmt.Errorf("%w: column %s of table %s is indexed and needs a size between 1 and %d, got %d",
+				ErrInvalidIndexedColumnSize, field.DBName, stmt.Table, maxIndexedColumnSize, field.Size)
+		}
+	}
+	return nil
+}
+
+func indexFields(idx schema.Index) []*schema.Field {
+	fields := make([]*schema.Field, len(idx.Fields))
+	for i, f := range idx.Fields {
+		fields[i] = f.Field
+	}
+	return fields
+}
+
 func (m Migrator) HasTable(value interface{}) bool {
 	var count int
-	m.Migrator.RunWithValue(value, func(stmt *gorm.Statement) error {
+	m.RunWithValue(value, func(stmt *gorm.Statement) error {
 		return executeOnImmuClient(m.DB, func(ic client.ImmuClient) error {
-			_, er := ic.DescribeTable(context.Background(), stmt.Table)
+			_, er := ic.DescribeTable(context.Background(), m.tableName(stmt))
 			if er != nil {
 				st, ok := status.FromError(er)
 				if ok && st.Message() == "table does not exist" {
@@ -118,57 +221,201 @@ func (m Migrator) HasTable(value interface{}) bool {
 	return count > 0
 }
 
+func (m Migrator) GetTables() (tableList []string, err error) {
+	err = executeOnImmuClient(m.DB, func(ic client.ImmuClient) error {
+		resp, err := ic.ListTables(context.Background())
+		if err != nil {
+			return err
+		}
+		for _, r := range resp.Rows {
+			tableList = append(tableList, r.Values[0].GetS())
+		}
+		return nil
+	})
+	sort.Strings(tableList)
+	return
+}
+
 func (m Migrator) DropTable(values ...interface{}) error {
 	return errors.New("not implemented")
 }
 
 func (m Migrator) HasColumn(value interface{}, name string) bool {
 	var count int
-	m.Migrator.RunWithValue(value, func(stmt *gorm.Statement) error {
-		return executeOnImmuClient(m.DB, func(ic client.ImmuClient) error {
-			resp, er := ic.DescribeTable(context.Background(), stmt.Table)
-			if er != nil {
-				return er
-			}
-			for _, v := range resp.Columns {
-				if v.Name == name {
-					count = 1
-				}
+	m.RunWithValue(value, func(stmt *gorm.Statement) error {
+		if field := stmt.Schema.LookUpField(name); field != nil {
+			name = field.DBName
+		}
+		columns, er := m.describeTable(m.tableName(stmt))
+		if er != nil {
+			return er
+		}
+		for _, c := range columns {
+			if c.name == name {
+				count = 1
 			}
-			return nil
-		})
+		}
+		return nil
 	})
 	return count > 0
 }
 
+func (m Migrator) AddColumn(value interface{}, name string) error {
+	return m.RunWithValue(value, func(stmt *gorm.Statement) error {
+		field := stmt.Schema.LookUpField(name)
+		if field == nil {
+			return fmt.Errorf("failed to look up field with name: %s", name)
+		}
+
+		if !field.IgnoreMigration {
+			return m.DB.Exec(
+				"ALTER TABLE ? ADD COLUMN ? ?",
+				m.CurrentTable(stmt), clause.Column{Name: field.DBName}, m.DB.Migrator().FullDataTypeOf(field),
+			).Error
+		}
+
+		return nil
+	})
+}
+
+// AlterColumn changes the type of a column to the one of the model field. immudb cannot alter columns,
+// so it is emulated with a table rebuild when TableRebuild is enabled.
 func (m Migrator) AlterColumn(value interface{}, name string) error {
-	return errors.New("not implemented")
+	return m.rebuildTable(value, func(stmt *gorm.Statement, columns []Column) ([]Column, error) {
+		field := stmt.Schema.LookUpField(name)
+		if field == nil {
+			return nil, fmt.Errorf("failed to look up field with name: %s", name)
+		}
+		dataType, length := splitDataType(m.DataTypeOf(field))
+		for i, c := range columns {
+			if c.name == field.DBName {
+				columns[i].datatype = dataType
+				columns[i].maxlen = sql.NullInt64{Int64: length, Valid: length > 0}
+				return columns, nil
+			}
+		}
+		return nil, fmt.Errorf("column %s not found in table %s", field.DBName, stmt.Table)
+	})
 }
 
+// DropColumn removes a column. immudb cannot drop columns, so it is emulated with a table rebuild
+// when TableRebuild is enabled.
 func (m Migrator) DropColumn(value interface{}, name string) error {
-	return errors.New("not implemented")
+	return m.rebuildTable(value, func(stmt *gorm.Statement, columns []Column) ([]Column, error) {
+		if field := stmt.Schema.LookUpField(name); field != nil {
+			name = field.DBName
+		}
+		for i, c := range columns {
+			if c.name == name {
+				if isPrimaryKey, _ := c.PrimaryKey(); isPrimaryKey {
+					return nil, fmt.Errorf("%w: cannot drop primary key column %s", ErrUnsupportedSchemaChange, name)
+				}
+				return append(columns[:i], columns[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("column %s not found in table %s", name, stmt.Table)
+	})
 }
 
-func (m Migrator) CreateConstraint(interface{}, string) error {
-	return errors.New("not implemented")
+// CreateConstraint enables the client side enforcement of a constraint of the model relationships
+func (m Migrator) CreateConstraint(value interface{}, name string) error {
+	dialector, ok := m.Dialector.(*Dialector)
+	if !ok || !dialector.cfg.ForeignKeys {
+		return ErrConstraintsNotImplemented
+	}
+	return m.RunWithValue(value, func(stmt *gorm.Statement) error {
+		dialector.foreignKeys.register(stmt.Schema)
+		if defined, _ := dialector.foreignKeys.lookup(stmt.Schema.Table, name); !defined {
+			return fmt.Errorf("failed to create constraint with name %v", name)
+		}
+		dialector.foreignKeys.setDropped(stmt.Schema.Table, name, false)
+		return nil
+	})
 }
 
-func (m Migrator) DropConstraint(interface{}, string) error {
-	return errors.New("not implemented")
+// DropConstraint disables the client side enforcement of a constraint until it is created again
+func (m Migrator) DropConstraint(value interface{}, name string) error {
+	dialector, ok := m.Dialector.(*Dialector)
+	if !ok || !dialector.cfg.ForeignKeys {
+		return ErrConstraintsNotImplemented
+	}
+	return m.RunWithValue(value, func(stmt *gorm.Statement) error {
+		dialector.foreignKeys.register(stmt.Schema)
+		dialector.foreignKeys.setDropped(stmt.Schema.Table, name, true)
+		return nil
+	})
 }
 
 func (m Migrator) HasConstraint(value interface{}, name string) bool {
-	return false
+	dialector, ok := m.Dialector.(*Dialector)
+	if !ok || !dialector.cfg.ForeignKeys {
+		return false
+	}
+	var has bool
+	m.RunWithValue(value, func(stmt *gorm.Statement) error {
+		dialector.foreignKeys.register(stmt.Schema)
+		defined, dropped := dialector.foreignKeys.lookup(stmt.Schema.Table, name)
+		has = defined && !dropped
+		return nil
+	})
+	return has
 }
 
 func (m Migrator) CurrentDatabase() (name string) {
-	return "defaultdb"
+	_ = executeOnImmuClient(m.DB, func(ic client.ImmuClient) error {
+		name = ic.GetOptions().Database
+		return nil
+	})
+	return name
+}
+
+// CreateDatabase creates a new immudb database. Use UseDatabase to open a session on it.
+func (m Migrator) CreateDatabase(name string) error {
+	return executeOnImmuClient(m.DB, func(ic client.ImmuClient) error {
+		return ic.CreateDatabase(context.Background(), &immuschema.DatabaseSettings{DatabaseName: name})
+	})
+}
+
+// ListDatabases returns the names of the databases available to the connected user
+func (m Migrator) ListDatabases() ([]string, error) {
+	var names []string
+	err := executeOnImmuClient(m.DB, func(ic client.ImmuClient) error {
+		resp, err := ic.DatabaseList(context.Background())
+		if err != nil {
+			return err
+		}
+		for _, d := range resp.Databases {
+			names = append(names, d.DatabaseName)
+		}
+		return nil
+	})
+	return names, err
+}
+
+func (m Migrator) HasDatabase(name string) bool {
+	names, err := m.ListDatabases()
+	if err != nil {
+		return false
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
 }
 
 func (m Migrator) CreateIndex(value interface{}, name string) error {
 	return m.RunWithValue(value, func(stmt *gorm.Statement) error {
 		if idx := stmt.Schema.LookIndex(name); idx != nil {
-			values := []interface{}{clause.Table{Name: stmt.Table}, clause.Column{Name: idx.Fields[0].DBName}}
+			if err := m.checkIndexedFieldSizes(stmt, indexFields(*idx)); err != nil {
+				return err
+			}
+			columns := make([]interface{}, len(idx.Fields))
+			for i, f := range idx.Fields {
+				columns[i] = clause.Column{Name: f.DBName}
+			}
+			values := []interface{}{m.CurrentTable(stmt), columns}
 			createIndexSQL := "CREATE "
 			if idx.Class != "" {
 				createIndexSQL += idx.Class + " "
@@ -186,7 +433,22 @@ func (m Migrator) CreateIndex(value interface{}, name string) error {
 func (m Migrator) HasIndex(value interface{}, name string) bool {
 	var count int
 	m.RunWithValue(value, func(stmt *gorm.Statement) error {
-		return nil
+		idx := stmt.Schema.LookIndex(name)
+		if idx == nil {
+			return nil
+		}
+		// immudb neither names indexes nor lists their columns: the index is there when a read can use it
+		columns := make([]string, len(idx.Fields))
+		for i, field := range idx.Fields {
+			columns[i] = field.DBName
+		}
+		query := fmt.Sprintf("SELECT %s FROM %s USE INDEX ON (%s) LIMIT 1", columns[0], m.tableName(stmt), strings.Join(columns, ", "))
+		return executeOnImmuClient(m.DB, func(ic client.ImmuClient) error {
+			if _, err := ic.SQLQuery(context.Background(), query, nil, false); err == nil {
+				count = 1
+			}
+			return nil
+		})
 	})
 	return count > 0
 }
@@ -206,27 +468,65 @@ func (m Migrator) DropIndex(value interface{}, name string) error {
 func (m Migrator) ColumnTypes(value interface{}) ([]gorm.ColumnType, error) {
 	columnTypes := make([]gorm.ColumnType, 0)
 	execErr := m.RunWithValue(value, func(stmt *gorm.Statement) error {
-		return executeOnImmuClient(m.DB, func(ic client.ImmuClient) error {
-			resp, err := ic.DescribeTable(context.Background(), stmt.Table)
-			if err != nil {
-				return err
-			}
-			for _, r := range resp.Rows {
-				for _, c := range r.GetValues() {
-					// @todo add missing properties in column description. Not sure where they are needed
-					column := Column{
-						name: c.GetS(),
-					}
-					columnTypes = append(columnTypes, column)
-					break
+		columns, err := m.describeTable(m.tableName(stmt))
+		if err != nil {
+			return err
+		}
+		for _, c := range columns {
+			// immudb has no column defaults, they are filled on the client side from the model
+			if stmt.Schema != nil {
+				if field := stmt.Schema.LookUpField(c.name); field != nil && field.HasDefaultValue && field.DefaultValue != "" {
+					c.defaultValue = sql.NullString{String: field.DefaultValue, Valid: true}
 				}
 			}
-			return nil
-		})
+			columnTypes = append(columnTypes, c)
+		}
+		return nil
 	})
 	return columnTypes, execErr
 }
 
+// describeTable returns the columns of a table as reported by immudb catalog
+func (m Migrator) describeTable(table string) ([]Column, error) {
+	columns := make([]Column, 0)
+	err := executeOnImmuClient(m.DB, func(ic client.ImmuClient) error {
+		resp, err := ic.DescribeTable(context.Background(), table)
+		if err != nil {
+			return err
+		}
+		for _, r := range resp.Rows {
+			values := r.GetValues()
+			if len(values) < 6 {
+				continue
+			}
+			column := Column{
+				name:          values[0].GetS(),
+				datatype:      values[1].GetS(),
+				index:         values[3].GetS(),
+				autoIncrement: values[4].GetB(),
+				unique:        values[5].GetB(),
+			}
+			if column.index == "" {
+				column.index = "NO"
+			}
+			if i := strings.Index(column.datatype, "["); i > 0 {
+				if n, err := strconv.ParseInt(strings.Trim(column.datatype[i:], "[]"), 10, 64); err == nil {
+					column.maxlen = sql.NullInt64{Int64: n, Valid: true}
+				}
+				column.datatype = column.datatype[:i]
+			}
+			if values[2].GetB() {
+				column.nullable = sql.NullString{String: "YES", Valid: true}
+			} else {
+				column.nullable = sql.NullString{String: "NO", Valid: true}
+			}
+			columns = append(columns, column)
+		}
+		return nil
+	})
+	return columns, err
+}
+
 type Column struct {
 	name              string
 	nullable          sql.NullString
@@ -236,6 +536,10 @@ type Column struct {
 	radix             sql.NullInt64
 	scale             sql.NullInt64
 	datetimeprecision sql.NullInt64
+	index             string
+	autoIncrement     bool
+	unique            bool
+	defaultValue      sql.NullString
 }
 
 func (c Column) Name() string {
@@ -246,6 +550,33 @@ func (c Column) DatabaseTypeName() string {
 	return c.datatype
 }
 
+func (c Column) ColumnType() (columnType string, ok bool) {
+	if c.maxlen.Valid {
+		return fmt.Sprintf("%s[%d]", c.datatype, c.maxlen.Int64), true
+	}
+	return c.datatype, c.datatype != ""
+}
+
+func (c Column) ScanType() reflect.Type {
+	switch c.datatype {
+	case "INTEGER":
+		return reflect.TypeOf(int64(0))
+	case "BOOLEAN":
+		return reflect.TypeOf(false)
+	case "VARCHAR":
+		return reflect.TypeOf("")
+	case "BLOB":
+		return reflect.TypeOf([]byte(nil))
+	case "TIMESTAMP":
+		return reflect.TypeOf(time.Time{})
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (c Column) Comment() (value string, ok bool) {
+	return "", false
+}
+
 func (c Column) Length() (length int64, ok bool) {
 	ok = c.maxlen.Valid
 	if ok {
@@ -265,6 +596,26 @@ func (c Column) Nullable() (nullable bool, ok bool) {
 	return
 }
 
+func (c Column) PrimaryKey() (isPrimaryKey bool, ok bool) {
+	return c.index == "PRIMARY KEY", c.index != ""
+}
+
+func (c Column) Indexed() (indexed bool, ok bool) {
+	return c.index != "NO", c.index != ""
+}
+
+func (c Column) AutoIncrement() (isAutoIncrement bool, ok bool) {
+	return c.autoIncrement, c.index != ""
+}
+
+func (c Column) Unique() (unique bool, ok bool) {
+	return c.unique, c.index != ""
+}
+
+func (c Column) DefaultValue() (value string, ok bool) {
+	return c.defaultValue.String, c.defaultValue.Valid
+}
+
 func (c Column) DecimalSize() (precision int64, scale int64, ok bool) {
 	if ok = c.precision.Valid && c.scale.Valid && c.radix.Valid && c.radix.Int64 == 10; ok {
 		precision, scale = c.precision.Int64, c.scale.Int64
@@ -276,6 +627,9 @@ func (c Column) DecimalSize() (precision int64, scale int64, ok bool) {
 	return
 }
 
-func (m *Migrator) RunWithoutForeignKey(fc func() error) error {
-	return ErrNotImplemented
+// RunWithoutForeignKey runs fc with a session of the migrator db whose statements skip the client side foreign key
+// enforcement. The other sessions keep enforcing the constraints.
+func (m Migrator) RunWithoutForeignKey(fc func(tx *gorm.DB) error) error {
+	ctx := context.WithValue(m.DB.Statement.Context, foreignKeysPaused{}, true)
+	return fc(m.DB.Session(&gorm.Session{NewDB: true, Context: ctx}))
 }
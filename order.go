@@ -0,0 +1,451 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"sort"
+	"strings"
+)
+
+// immudb orders the rows by a single column, which must lead one of the indexes of the table. The rows are then
+// read in the order of the whole key of the index, followed by the primary key for non unique indexes. Orders on
+// several columns are sent to immudb when an index is ordered like them, and sorted on the client otherwise.
+
+const (
+	defaultSortLimit = 10000
+	useIndexSetting  = "immudb:use_index"
+)
+
+// sortKey is a column of the order of a query
+type sortKey struct {
+	column string
+	desc   bool
+}
+
+// tableIndex is an index of a table: the primary key, a unique index or an index
+type tableIndex struct {
+	columns []string
+	unique  bool
+	primary bool
+}
+
+// sortingIndex is the index immudb reads the table with to order the rows like the query
+type sortingIndex struct {
+	columns []string
+	orderBy clause.OrderByColumn
+}
+
+// orderBy lets immudb order the rows when one of its indexes is ordered like the query, and sorts them on the
+// client otherwise
+func (dialector *Dialector) orderBy(db *gorm.DB) {
+	stmt := db.Statement
+	stmt.Settings.Delete(useIndexSetting)
+	c, ok := stmt.Clauses["ORDER BY"]
+	if _, grouped := stmt.Clauses["GROUP BY"]; db.Error != nil || !ok || grouped || stmt.Schema == nil || stmt.SQL.Len() > 0 {
+		return
+	}
+	orderBy, ok := c.Expression.(clause.OrderBy)
+	if !ok || orderBy.Expression != nil {
+		return
+	}
+	keys, ok := dialector.sortKeys(stmt, orderBy)
+	if !ok {
+		// unknown columns are left to immudb
+		return
+	}
+	for _, key := range keys {
+		// the ciphertext is not ordered like the values
+		if isEncryptedColumn(stmt.Schema, key.column) {
+			db.AddError(fmt.Errorf("%w: %s is encrypted", ErrUnsupportedOrder, key.column))
+			return
+		}
+	}
+
+	indexes := dialector.tableIndexes(stmt.Schema)
+	if len(keys) == 1 {
+		for _, idx := range indexes {
+			if idx.columns[0] == keys[0].column {
+				return
+			}
+		}
+	}
+	if columns, ok := sortedBy(indexes, keys); ok {
+		stmt.Settings.Store(useIndexSetting, sortingIndex{
+			columns: columns,
+			orderBy: clause.OrderByColumn{Column: clause.Column{Name: keys[0].column}, Desc: keys[0].desc},
+		})
+		return
+	}
+
+	limit := dialector.sortLimit()
+	if limit < 0 {
+		db.AddError(fmt.Errorf("%w: no index of table %s is ordered by %s and sorting on the client is disabled", ErrUnsupportedOrder, stmt.Table, formatSortKeys(keys)))
+		return
+	}
+	if onServerOnly(db, "the order by "+formatSortKeys(keys)) || db.DryRun {
+		return
+	}
+	rows, err := sortRows(db, keys, limit)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	callbacks.BuildQuerySQL(db)
+	stmt.ConnPool = &resultConnPool{ConnPool: stmt.ConnPool, sql: stmt.SQL.String(), rows: rows}
+}
+
+func (dialector *Dialector) sortLimit() int {
+	if dialector.cfg != nil && dialector.cfg.SortLimit != 0 {
+		return dialector.cfg.SortLimit
+	}
+	return defaultSortLimit
+}
+
+func formatSortKeys(keys []sortKey) string {
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		columns[i] = key.column
+		if key.desc {
+			columns[i] += " DESC"
+		}
+	}
+	return strings.Join(columns, ", ")
+}
+
+// sortKeys returns the columns of the order, which must be columns of the table of the statement
+func (dialector *Dialector) sortKeys(stmt *gorm.Statement, orderBy clause.OrderBy) ([]sortKey, bool) {
+	var keys []sortKey
+	for _, column := range orderBy.Columns {
+		if column.Column.Table != "" && column.Column.Table != clause.CurrentTable && column.Column.Table != stmt.Table {
+			return nil, false
+		}
+		texts := []string{column.Column.Name}
+		if column.Column.Raw {
+			texts = splitList(column.Column.Name)
+		}
+		for _, text := range texts {
+			key := sortKey{desc: column.Desc}
+			if m := orderRegexp.FindStringSubmatch(text); m != nil {
+				text, key.desc = strings.TrimSpace(m[1]), strings.EqualFold(m[2], "desc")
+			}
+
+			name := text
+			switch {
+			case name == clause.PrimaryKey && stmt.Schema.PrioritizedPrimaryField != nil:
+				name = stmt.Schema.PrioritizedPrimaryField.DBName
+			case name == clause.PrimaryKey && dialector.hasSurrogateKey(stmt.Schema):
+				name = SurrogateKeyColumn
+			case !columnRegexp.MatchString(name):
+				return nil, false
+			}
+			if i := strings.LastIndexByte(name, '.'); i >= 0 {
+				if !strings.EqualFold(name[:i], stmt.Table) {
+					return nil, false
+				}
+				name = name[i+1:]
+			}
+
+			if field := stmt.Schema.LookUpField(name); field != nil && field.DBName != "" {
+				key.column = field.DBName
+			} else if name == SurrogateKeyColumn && dialector.hasSurrogateKey(stmt.Schema) {
+				key.column = name
+			} else {
+				return nil, false
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, len(keys) > 0
+}
+
+// tableIndexes returns the indexes of the table of the schema
+func (dialector *Dialector) tableIndexes(s *schema.Schema) []tableIndex {
+	var indexes []tableIndex
+	if dialector.hasSurrogateKey(s) {
+		indexes = append(indexes, tableIndex{columns: []string{SurrogateKeyColumn}, unique: true, primary: true})
+	} else if len(s.PrimaryFieldDBNames) > 0 {
+		indexes = append(indexes, tableIndex{columns: s.PrimaryFieldDBNames, unique: true, primary: true})
+	}
+	for _, field := range s.Fields {
+		if field.Unique && field.DBName != "" && !(field.PrimaryKey && len(s.PrimaryFields) == 1) {
+			indexes = append(indexes, tableIndex{columns: []string{field.DBName}, unique: true})
+		}
+	}
+	for _, idx := range s.ParseIndexes() {
+		columns := make([]string, len(idx.Fields))
+		for i, f := range idx.Fields {
+			columns[i] = f.DBName
+		}
+		indexes = append(indexes, tableIndex{columns: columns, unique: idx.Class == "UNIQUE"})
+	}
+	return indexes
+}
+
+// sortedBy returns the columns of an index whose key is ordered like the keys, all in the same direction
+func sortedBy(indexes []tableIndex, keys []sortKey) ([]string, bool) {
+	for _, key := range keys {
+		if key.desc != keys[0].desc {
+			return nil, false
+		}
+	}
+	var primaryKey []string
+	for _, idx := range indexes {
+		if idx.primary {
+			primaryKey = idx.columns
+		}
+	}
+
+	for _, idx := range indexes {
+		// the primary key follows the columns of non unique indexes in their key
+		key := idx.columns
+		if !idx.unique {
+			if primaryKey == nil {
+				continue
+			}
+			key = append(append([]string(nil), idx.columns...), primaryKey...)
+		}
+		matches := true
+		for i := 0; i < len(keys) && i < len(key) && matches; i++ {
+			matches = keys[i].column == key[i]
+		}
+		if matches {
+			// the keys following a whole unique key order nothing
+			return idx.columns, true
+		}
+	}
+	return nil, false
+}
+
+// sortRows reads the rows of the query without order and sorts them, keeping at most limit rows in memory
+func sortRows(db *gorm.DB, keys []sortKey, limit int) (*resultRows, error) {
+	tx := db.Session(&gorm.Session{Initialized: true})
+	var lim clause.Limit
+	if c, ok := tx.Statement.Clauses["LIMIT"]; ok {
+		lim, _ = c.Expression.(clause.Limit)
+	}
+	delete(tx.Statement.Clauses, "ORDER BY")
+	delete(tx.Statement.Clauses, "LIMIT")
+	added := selectSortColumns(tx.Statement, keys)
+
+	rows, err := tx.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	types := make([]string, len(columnTypes))
+	for i, columnType := range columnTypes {
+		types[i] = columnType.DatabaseTypeName()
+	}
+	positions := make([]int, len(keys))
+	for i, key := range keys {
+		positions[i] = -1
+		for j, column := range columns {
+			if unqualified(column) == key.column {
+				positions[i] = j
+				break
+			}
+		}
+		if positions[i] < 0 {
+			return nil, fmt.Errorf("%w: %s is not selected", ErrUnsupportedOrder, key.column)
+		}
+	}
+
+	var (
+		sorted = &sortedRows{keys: keys, positions: positions}
+		keep   int
+		values = make([]interface{}, len(columns))
+		ptrs   = make([]interface{}, len(columns))
+	)
+	if lim.Limit > 0 {
+		keep = lim.Offset + lim.Limit
+	}
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make([]driver.Value, len(values))
+		for i, v := range values {
+			row[i] = v
+		}
+		sorted.rows = append(sorted.rows, row)
+
+		if len(sorted.rows) > limit {
+			// only the rows within the limit of the query are kept
+			if keep == 0 || keep >= limit {
+				return nil, fmt.Errorf("%w: more than %d rows to sort on the client", ErrUnsupportedOrder, limit)
+			}
+			sort.Stable(sorted)
+			sorted.rows = sorted.rows[:keep]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Stable(sorted)
+
+	result := sorted.rows
+	if lim.Offset > 0 {
+		if lim.Offset > len(result) {
+			lim.Offset = len(result)
+		}
+		result = result[lim.Offset:]
+	}
+	if lim.Limit > 0 && lim.Limit < len(result) {
+		result = result[:lim.Limit]
+	}
+	// the columns added to sort the rows are not returned
+	n := len(columns) - added
+	for i, row := range result {
+		result[i] = row[:n]
+	}
+	return &resultRows{columns: columns[:n], types: types[:n], values: result}, nil
+}
+
+// selectSortColumns adds the columns of the keys missing from the selected ones, returning how many were added
+func selectSortColumns(stmt *gorm.Statement, keys []sortKey) int {
+	var (
+		selected []string
+		sel      clause.Select
+	)
+	c, hasClause := stmt.Clauses["SELECT"]
+	if hasClause {
+		var ok bool
+		if sel, ok = c.Expression.(clause.Select); !ok || sel.Expression != nil {
+			return 0
+		}
+		for _, column := range sel.Columns {
+			if column.Raw {
+				selected = append(selected, splitList(column.Name)...)
+			} else {
+				selected = append(selected, column.Name)
+			}
+		}
+	} else {
+		for _, s := range stmt.Selects {
+			selected = append(selected, splitList(s)...)
+		}
+	}
+	if len(selected) == 0 || stmt.Distinct {
+		return 0
+	}
+
+	names := map[string]bool{}
+	for _, s := range selected {
+		if s == "*" || strings.HasSuffix(s, ".*") {
+			return 0
+		}
+		if m := aliasRegexp.FindStringSubmatch(s); m != nil {
+			s = m[2]
+		} else if field := stmt.Schema.LookUpField(s); field != nil {
+			s = field.DBName
+		}
+		names[unqualified(s)] = true
+	}
+
+	added := 0
+	for _, key := range keys {
+		if names[key.column] {
+			continue
+		}
+		names[key.column] = true
+		added++
+		if hasClause {
+			sel.Columns = append(sel.Columns, clause.Column{Name: key.column})
+		} else {
+			stmt.Selects = append(stmt.Selects, key.column)
+		}
+	}
+	if hasClause {
+		c.Expression = sel
+		stmt.Clauses["SELECT"] = c
+	}
+	return added
+}
+
+type sortedRows struct {
+	keys      []sortKey
+	positions []int
+	rows      [][]driver.Value
+}
+
+func (s *sortedRows) Len() int {
+	return len(s.rows)
+}
+
+func (s *sortedRows) Swap(i, j int) {
+	s.rows[i], s.rows[j] = s.rows[j], s.rows[i]
+}
+
+func (s *sortedRows) Less(i, j int) bool {
+	for k, key := range s.keys {
+		x, y := s.rows[i][s.positions[k]], s.rows[j][s.positions[k]]
+		if cmp, _ := compareValues(x, y); cmp != 0 {
+			return (cmp < 0) != key.desc
+		}
+	}
+	return false
+}
+
+// indexedFrom is a FROM clause reading the table with an index
+type indexedFrom struct {
+	clause.From
+	index []string
+}
+
+func (from indexedFrom) Build(builder clause.Builder) {
+	clause.From{Tables: from.Tables}.Build(builder)
+	builder.WriteString(" USE INDEX ON (")
+	for idx, column := range from.index {
+		if idx > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteQuoted(column)
+	}
+	builder.WriteByte(')')
+	for _, join := range from.Joins {
+		builder.WriteByte(' ')
+		join.Build(builder)
+	}
+}
+
+// buildOrderBy orders by the first column of the index the table is read with, which orders by the whole key
+func buildOrderBy(c clause.Clause, builder clause.Builder) {
+	if stmt, ok := builder.(*gorm.Statement); ok {
+		if v, ok := stmt.Settings.Load(useIndexSetting); ok {
+			c.Expression = clause.OrderBy{Columns: []clause.OrderByColumn{v.(sortingIndex).orderBy}}
+		}
+	}
+	c.Build(builder)
+}
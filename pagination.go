@@ -0,0 +1,313 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/codenotary/immudb/pkg/client"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+// immudb has no OFFSET: the offset is added to the limit and the rows of the offset are skipped on the client, which
+// still reads them. Paginate reads the records following the key of the last record of the previous page instead,
+// which immudb seeks to in the index ordering the rows.
+
+const paginationSetting = "immudb:pagination"
+
+// buildLimit folds the offset into the limit, see skipOffset
+func buildLimit(c clause.Clause, builder clause.Builder) {
+	if limit, ok := c.Expression.(clause.Limit); ok && limit.Offset > 0 {
+		if limit.Limit > 0 {
+			limit.Limit += limit.Offset
+		}
+		limit.Offset = 0
+		c.Expression = limit
+	}
+	c.Build(builder)
+}
+
+// skipOffset skips the rows of the offset of the query on the client
+func (dialector *Dialector) skipOffset(db *gorm.DB) {
+	stmt := db.Statement
+	c, ok := stmt.Clauses["LIMIT"]
+	limit, isLimit := c.Expression.(clause.Limit)
+	if db.Error != nil || !ok || !isLimit || limit.Offset <= 0 || stmt.SQL.Len() > 0 || onServerOnly(db, "the offset") || db.DryRun {
+		return
+	}
+	callbacks.BuildQuerySQL(db)
+	stmt.ConnPool = &resultConnPool{ConnPool: stmt.ConnPool, sql: stmt.SQL.String(), offset: limit.Offset}
+}
+
+// cursor is the content of the tokens of the pages
+type cursor struct {
+	Columns []string          `json:"c,omitempty"`
+	Desc    bool              `json:"d,omitempty"`
+	Values  []json.RawMessage `json:"v,omitempty"`
+	TxID    uint64            `json:"tx,omitempty"`
+}
+
+func decodeCursor(token string) (cursor, error) {
+	var c cursor
+	if token == "" {
+		return c, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return c, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	return c, nil
+}
+
+func (c cursor) encode() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// pagination is a page read by Paginate
+type pagination struct {
+	fields []*schema.Field
+	desc   bool
+	size   int
+	txID   uint64
+}
+
+// Paginate reads the page of size records following the page of the token, the first page if the token is empty.
+// The records are ordered by the order of db, on a single column, then by primary key, or by primary key only.
+// NextCursor returns the token of the next page once the page is read:
+//
+//	tx := immugorm.Paginate(db.Order("created_at desc"), token, 20).Find(&events)
+//	token, err = immugorm.NextCursor(tx)
+func Paginate(db *gorm.DB, token string, size int) *gorm.DB {
+	return db.Scopes(func(tx *gorm.DB) *gorm.DB {
+		c, err := decodeCursor(token)
+		if err != nil {
+			tx.AddError(err)
+			return tx
+		}
+		p, err := newPagination(tx, c, size)
+		if err != nil {
+			tx.AddError(err)
+			return tx
+		}
+
+		columns := make([]clause.OrderByColumn, len(p.fields))
+		for i, field := range p.fields {
+			columns[i] = clause.OrderByColumn{Column: clause.Column{Name: field.DBName}, Desc: p.desc}
+		}
+		tx.Statement.Clauses["ORDER BY"] = clause.Clause{Name: "ORDER BY", Expression: clause.OrderBy{Columns: columns}}
+		if len(c.Values) > 0 {
+			names := make([]string, len(p.fields))
+			values := make([]interface{}, len(p.fields))
+			for i, field := range p.fields {
+				names[i] = field.DBName
+				if values[i], err = decodeCursorValue(field, c.Values[i]); err != nil {
+					tx.AddError(err)
+					return tx
+				}
+			}
+			tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{keysetCondition(names, values, p.desc)}})
+		}
+		if c.TxID > 0 {
+			tx = tx.Clauses(BeforeTx(c.TxID + 1))
+		}
+		return tx.Limit(size).Set(paginationSetting, p)
+	})
+}
+
+// newPagination returns the page of the query, ordered by its order and by primary key
+func newPagination(tx *gorm.DB, c cursor, size int) (*pagination, error) {
+	stmt := tx.Statement
+	model := stmt.Model
+	if model == nil {
+		model = stmt.Dest
+	}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	dialector, ok := tx.Dialector.(*Dialector)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	if len(stmt.Schema.PrimaryFields) == 0 {
+		return nil, fmt.Errorf("%w: pages of table %s without a primary key", ErrUnsupportedOrder, stmt.Table)
+	}
+
+	var keys []sortKey
+	if cl, ok := stmt.Clauses["ORDER BY"]; ok {
+		orderBy, _ := cl.Expression.(clause.OrderBy)
+		if keys, ok = dialector.sortKeys(stmt, orderBy); !ok || orderBy.Expression != nil {
+			return nil, fmt.Errorf("%w: pages ordered by something else than a column of table %s", ErrUnsupportedOrder, stmt.Table)
+		}
+	}
+
+	p := &pagination{size: size, txID: c.TxID}
+	if len(keys) > 0 && keys[0].column != stmt.Schema.PrimaryFields[0].DBName {
+		p.fields = append(p.fields, stmt.Schema.LookUpField(keys[0].column))
+	}
+	p.fields = append(p.fields, stmt.Schema.PrimaryFields...)
+	if len(keys) > 0 {
+		p.desc = keys[0].desc
+	}
+	// the primary key may follow the column in the order
+	for i, key := range keys {
+		if i >= len(p.fields) || key.column != p.fields[i].DBName || key.desc != p.desc {
+			return nil, fmt.Errorf("%w: pages ordered by %s", ErrUnsupportedOrder, formatSortKeys(keys))
+		}
+	}
+
+	if len(c.Values) > 0 {
+		if c.Desc != p.desc || len(c.Columns) != len(p.fields) || len(c.Values) != len(p.fields) {
+			return nil, fmt.Errorf("%w: the token is of pages with another order", ErrInvalidCursor)
+		}
+		for i, field := range p.fields {
+			if c.Columns[i] != field.DBName {
+				return nil, fmt.Errorf("%w: the token is of pages with another order", ErrInvalidCursor)
+			}
+		}
+	}
+	return p, nil
+}
+
+// keysetCondition selects the rows following the key values in the order
+func keysetCondition(columns []string, values []interface{}, desc bool) clause.Expression {
+	following := func(column clause.Column, value interface{}) clause.Expression {
+		if desc {
+			return clause.Lt{Column: column, Value: value}
+		}
+		return clause.Gt{Column: column, Value: value}
+	}
+
+	conds := make([]clause.Expression, len(columns))
+	for i, column := range columns {
+		exprs := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			exprs = append(exprs, clause.Eq{Column: clause.Column{Name: columns[j]}, Value: values[j]})
+		}
+		conds[i] = clause.And(append(exprs, following(clause.Column{Name: column}, values[i]))...)
+	}
+	if len(conds) == 1 {
+		return conds[0]
+	}
+
+	// the bound on the first column lets immudb seek the first row in the index
+	first := clause.Column{Name: columns[0]}
+	var bound clause.Expression = clause.Gte{Column: first, Value: values[0]}
+	if desc {
+		bound = clause.Lte{Column: first, Value: values[0]}
+	}
+	return clause.And(bound, clause.Or(conds...))
+}
+
+// NextCursor returns the token of the page following the one read with Paginate, empty if it was the last page
+func NextCursor(db *gorm.DB) (string, error) {
+	if db.Error != nil {
+		return "", db.Error
+	}
+	v, ok := db.Get(paginationSetting)
+	if !ok {
+		return "", fmt.Errorf("%w: the records were not read with Paginate", ErrInvalidCursor)
+	}
+	p := v.(*pagination)
+
+	rv := reflect.Indirect(db.Statement.ReflectValue)
+	if (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) || p.size <= 0 || rv.Len() < p.size {
+		return "", nil
+	}
+	last := reflect.Indirect(rv.Index(rv.Len() - 1))
+
+	c := cursor{Desc: p.desc, TxID: p.txID}
+	for _, field := range p.fields {
+		value, err := cursorValue(db.Statement.Context, field, last)
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		c.Columns = append(c.Columns, field.DBName)
+		c.Values = append(c.Values, data)
+	}
+	return c.encode()
+}
+
+// cursorValue returns the value of the field of the record as it is sent to immudb. The pages following a NULL value
+// would be selected by comparisons with NULL, so it is an error.
+func cursorValue(ctx context.Context, field *schema.Field, record reflect.Value) (interface{}, error) {
+	value := reflect.Indirect(field.ReflectValueOf(ctx, record))
+	if !value.IsValid() {
+		return nil, fmt.Errorf("%w: pages ordered by %s, which is NULL in the last record", ErrUnsupportedOrder, field.DBName)
+	}
+	v := value.Interface()
+	if valuer, ok := v.(driver.Valuer); ok {
+		var err error
+		if v, err = valuer.Value(); err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, fmt.Errorf("%w: pages ordered by %s, which is NULL in the last record", ErrUnsupportedOrder, field.DBName)
+		}
+	}
+	return v, nil
+}
+
+// decodeCursorValue decodes a value of the field returned by cursorValue, a value of the type the field is sent as
+func decodeCursorValue(field *schema.Field, data json.RawMessage) (interface{}, error) {
+	typ := field.IndirectFieldType
+	if valuer, ok := reflect.New(typ).Elem().Interface().(driver.Valuer); ok {
+		if v, err := valuer.Value(); err == nil && v != nil {
+			typ = reflect.TypeOf(v)
+		}
+	}
+	value := reflect.New(typ)
+	if err := json.Unmarshal(data, value.Interface()); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	return value.Elem().Interface(), nil
+}
+
+// PinCursor returns the token of a first page of Paginate reading the records as of the last transaction, as do
+// the following pages, so that they are not changed by the later transactions. Such pages cannot be verified.
+func PinCursor(db *gorm.DB) (string, error) {
+	var txID uint64
+	err := executeOnImmuClient(db, func(ic client.ImmuClient) error {
+		state, err := ic.CurrentState(db.Statement.Context)
+		if err != nil {
+			return err
+		}
+		txID = state.TxId
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return cursor{TxID: txID}.encode()
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaChange describes a difference between a model and the live table that immudb is not able to apply
+type SchemaChange struct {
+	Table  string
+	Column string
+	Reason string
+}
+
+func (c SchemaChange) String() string {
+	if c.Column == "" {
+		return fmt.Sprintf("%s: %s", c.Table, c.Reason)
+	}
+	return fmt.Sprintf("%s.%s: %s", c.Table, c.Column, c.Reason)
+}
+
+// MigrationPlan lists the DDL statements needed to bring the database in line with the models,
+// and the changes that cannot be migrated.
+type MigrationPlan struct {
+	Statements  []string
+	Unsupported []SchemaChange
+}
+
+// Err returns ErrUnsupportedSchemaChange if the plan contains changes that cannot be migrated.
+func (p *MigrationPlan) Err() error {
+	if len(p.Unsupported) == 0 {
+		return nil
+	}
+	changes := make([]string, len(p.Unsupported))
+	for i, c := range p.Unsupported {
+		changes[i] = c.String()
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedSchemaChange, strings.Join(changes, "; "))
+}
+
+// Plan compares the models with the live immudb catalog without modifying it.
+func Plan(db *gorm.DB, models ...interface{}) (*MigrationPlan, error) {
+	plan := &MigrationPlan{}
+	recorder := &statementRecorder{Interface: logger.Discard}
+	tx := db.Session(&gorm.Session{DryRun: true, Logger: recorder})
+	m, ok := tx.Migrator().(Migrator)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNotImplemented, tx.Migrator())
+	}
+
+	for _, model := range m.ReorderModels(models, true) {
+		if !m.HasTable(model) {
+			if err := m.CreateTable(model); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err := m.RunWithValue(model, func(stmt *gorm.Statement) error {
+			columns, err := m.describeTable(m.tableName(stmt))
+			if err != nil {
+				return err
+			}
+			columnsByName := make(map[string]Column, len(columns))
+			for _, c := range columns {
+				columnsByName[c.name] = c
+			}
+
+			for _, dbName := range stmt.Schema.DBNames {
+				field := stmt.Schema.FieldsByDBName[dbName]
+				if field.IgnoreMigration {
+					continue
+				}
+				column, ok := columnsByName[dbName]
+				if !ok {
+					if field.PrimaryKey {
+						plan.Unsupported = append(plan.Unsupported, SchemaChange{Table: stmt.Table, Column: dbName, Reason: "primary key column added"})
+						continue
+					}
+					if err := m.AddColumn(model, dbName); err != nil {
+						return err
+					}
+					continue
+				}
+				delete(columnsByName, dbName)
+
+				expectedType, expectedLen := splitDataType(m.DataTypeOf(field))
+				if expectedType != column.datatype {
+					plan.Unsupported = append(plan.Unsupported, SchemaChange{
+						Table: stmt.Table, Column: dbName,
+						Reason: fmt.Sprintf("type change from %s to %s", column.datatype, expectedType),
+					})
+				} else if length, _ := column.Length(); length != expectedLen {
+					plan.Unsupported = append(plan.Unsupported, SchemaChange{
+						Table: stmt.Table, Column: dbName,
+						Reason: fmt.Sprintf("size change from %d to %d", length, expectedLen),
+					})
+				}
+				if isPrimaryKey, _ := column.PrimaryKey(); isPrimaryKey != field.PrimaryKey {
+					plan.Unsupported = append(plan.Unsupported, SchemaChange{Table: stmt.Table, Column: dbName, Reason: "primary key change"})
+				}
+				if isAutoIncrement, _ := column.AutoIncrement(); isAutoIncrement != strings.Contains(m.DataTypeOf(field), "AUTO_INCREMENT") {
+					plan.Unsupported = append(plan.Unsupported, SchemaChange{Table: stmt.Table, Column: dbName, Reason: "auto increment change"})
+				}
+			}
+
+			if m.hasSurrogateKey(stmt) {
+				delete(columnsByName, SurrogateKeyColumn)
+			}
+			dropped := make([]string, 0, len(columnsByName))
+			for name := range columnsByName {
+				dropped = append(dropped, name)
+			}
+			sort.Strings(dropped)
+			for _, name := range dropped {
+				plan.Unsupported = append(plan.Unsupported, SchemaChange{Table: stmt.Table, Column: name, Reason: "column dropped"})
+			}
+
+			for _, idx := range stmt.Schema.ParseIndexes() {
+				if !m.HasIndex(model, idx.Name) {
+					if err := m.CreateIndex(model, idx.Name); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	plan.Statements = recorder.statements
+	return plan, nil
+}
+
+// splitDataType splits a data type like VARCHAR[32] AUTO_INCREMENT into its base type and max length
+func splitDataType(dataType string) (string, int64) {
+	dataType = strings.Fields(dataType)[0]
+	i := strings.Index(dataType, "[")
+	if i < 0 {
+		return dataType, 0
+	}
+	n, _ := strconv.ParseInt(strings.Trim(dataType[i:], "[]"), 10, 64)
+	return dataType[:i], n
+}
+
+// statementRecorder is a logger collecting the statements built by a dry run session
+type statementRecorder struct {
+	logger.Interface
+	statements []string
+}
+
+func (r *statementRecorder) LogMode(logger.LogLevel) logger.Interface {
+	return r
+}
+
+func (r *statementRecorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
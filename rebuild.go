@@ -0,0 +1,402 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"context"
+	"fmt"
+	"github.com/codenotary/immudb/pkg/client"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultRebuildBatchSize = 100
+
+// TableVersion maps the table of a model to the physical table holding its rows after a rebuild.
+// Rebuilt tables are named <table>_v<version>, the original table being version 1, and are never dropped.
+type TableVersion struct {
+	Name          string `gorm:"primaryKey;size:255"`
+	PhysicalTable string `gorm:"size:255"`
+	Version       int
+	RebuiltAt     time.Time
+}
+
+func (TableVersion) TableName() string {
+	return "schema_table_versions"
+}
+
+func (dialector *Dialector) physicalTable(name string) string {
+	if v, ok := dialector.tableVersions.Load(name); ok {
+		return v.(string)
+	}
+	return name
+}
+
+func (dialector *Dialector) loadTableVersions(db *gorm.DB) error {
+	return executeOnImmuClient(db, func(ic client.ImmuClient) error {
+		resp, err := ic.SQLQuery(context.Background(), "SELECT name, physical_table FROM "+TableVersion{}.TableName(), nil, true)
+		if err != nil {
+			if st, ok := status.FromError(err); ok && st.Message() == "table does not exist" {
+				return nil
+			}
+			return err
+		}
+		for _, r := range resp.Rows {
+			dialector.tableVersions.Store(r.Values[0].GetS(), r.Values[1].GetS())
+		}
+		return nil
+	})
+}
+
+// useTableVersion points the statement to the physical table of a rebuilt model
+func (dialector *Dialector) useTableVersion(db *gorm.DB) {
+	if db.Statement.Table != "" {
+		db.Statement.Table = dialector.physicalTable(db.Statement.Table)
+	}
+}
+
+func (m Migrator) tableName(stmt *gorm.Statement) string {
+	if dialector, ok := m.Dialector.(*Dialector); ok {
+		return dialector.physicalTable(stmt.Table)
+	}
+	return stmt.Table
+}
+
+func (m Migrator) CurrentTable(stmt *gorm.Statement) interface{} {
+	if stmt.TableExpr != nil {
+		return *stmt.TableExpr
+	}
+	return clause.Table{Name: m.tableName(stmt)}
+}
+
+// rebuildTable emulates a schema change immudb cannot apply in place. A new versioned table is created with the
+// columns returned by target, the current rows are copied in batches, each one inside a transaction, and the model
+// is mapped to the new table. The previous table is left untouched, to keep its history.
+// Indexes are recreated as single column indexes on the columns that were indexed.
+// The table left by a failed rebuild is resumed if it has the same columns, or skipped otherwise, since immudb
+// cannot drop it: the model is only mapped to the new table once all the rows are copied.
+func (m Migrator) rebuildTable(value interface{}, target func(stmt *gorm.Statement, columns []Column) ([]Column, error)) error {
+	dialector, ok := m.Dialector.(*Dialector)
+	if !ok || dialector.cfg == nil || !dialector.cfg.TableRebuild {
+		return ErrNotImplemented
+	}
+
+	return m.RunWithValue(value, func(stmt *gorm.Statement) error {
+		current := m.tableName(stmt)
+		columns, err := m.describeTable(current)
+		if err != nil {
+			return err
+		}
+		oldColumns := make(map[string]Column, len(columns))
+		for _, c := range columns {
+			oldColumns[c.name] = c
+		}
+
+		newColumns, err := target(stmt, append([]Column(nil), columns...))
+		if err != nil {
+			return err
+		}
+
+		tx := m.DB.Session(&gorm.Session{NewDB: true})
+		if err = tx.AutoMigrate(&TableVersion{}); err != nil {
+			return err
+		}
+		var versions []TableVersion
+		if err = tx.Where("name = ?", stmt.Table).Find(&versions).Error; err != nil {
+			return err
+		}
+		version := TableVersion{Name: stmt.Table, Version: 1}
+		if len(versions) > 0 {
+			version = versions[0]
+		}
+		version.Version++
+		version.PhysicalTable = fmt.Sprintf("%s_v%d", stmt.Table, version.Version)
+		version.RebuiltAt = time.Now()
+
+		specs, primaryKeys := columnSpecs(newColumns)
+		if len(primaryKeys) == 0 {
+			return fmt.Errorf("%w: table %s would have no primary key", ErrUnsupportedSchemaChange, stmt.Table)
+		}
+		var names []string
+		for _, c := range newColumns {
+			if _, ok := oldColumns[c.name]; ok {
+				names = append(names, c.name)
+			}
+		}
+
+		resume := false
+		for !resume && m.HasTable(version.PhysicalTable) {
+			leftover, err := m.describeTable(version.PhysicalTable)
+			if err != nil {
+				return err
+			}
+			leftoverSpecs, leftoverKeys := columnSpecs(leftover)
+			resume = strings.Join(leftoverSpecs, ", ") == strings.Join(specs, ", ") &&
+				strings.Join(leftoverKeys, ", ") == strings.Join(primaryKeys, ", ")
+			if !resume {
+				version.Version++
+				version.PhysicalTable = fmt.Sprintf("%s_v%d", stmt.Table, version.Version)
+			}
+		}
+
+		createTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s, PRIMARY KEY (%s))", version.PhysicalTable, strings.Join(specs, ", "), strings.Join(primaryKeys, ", "))
+		if err = tx.Exec(createTableSQL).Error; err != nil {
+			return err
+		}
+		for _, c := range newColumns {
+			if isPrimaryKey, _ := c.PrimaryKey(); isPrimaryKey || c.index != "YES" {
+				continue
+			}
+			createIndexSQL := "CREATE INDEX IF NOT EXISTS ON %s (%s)"
+			if c.unique {
+				createIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS ON %s (%s)"
+			}
+			if err = tx.Exec(fmt.Sprintf(createIndexSQL, version.PhysicalTable, c.name)).Error; err != nil {
+				return err
+			}
+		}
+
+		// the rows are read in the order of the primary key of the current table
+		var keys []string
+		for _, c := range columns {
+			if isPrimaryKey, _ := c.PrimaryKey(); isPrimaryKey {
+				keys = append(keys, c.name)
+			}
+		}
+		if err = m.copyRows(tx, current, version.PhysicalTable, names, keys, oldColumns, newColumns, resume); err != nil {
+			return err
+		}
+
+		if len(versions) > 0 {
+			err = tx.Model(&version).Updates(map[string]interface{}{
+				"physical_table": version.PhysicalTable,
+				"version":        version.Version,
+				"rebuilt_at":     version.RebuiltAt,
+			}).Error
+		} else {
+			err = tx.Create(&version).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		dialector.tableVersions.Store(stmt.Table, version.PhysicalTable)
+		return nil
+	})
+}
+
+// columnSpecs returns the definitions of the columns, as written in CREATE TABLE, and the names of the primary keys
+func columnSpecs(columns []Column) (specs []string, primaryKeys []string) {
+	for _, c := range columns {
+		spec := c.name + " " + c.datatype
+		if length, ok := c.Length(); ok && length > 0 {
+			spec += fmt.Sprintf("[%d]", length)
+		}
+		isPrimaryKey, _ := c.PrimaryKey()
+		if nullable, ok := c.Nullable(); ok && !nullable && !isPrimaryKey {
+			spec += " NOT NULL"
+		}
+		if c.autoIncrement {
+			spec += " AUTO_INCREMENT"
+		}
+		specs = append(specs, spec)
+		if isPrimaryKey {
+			primaryKeys = append(primaryKeys, c.name)
+		}
+	}
+	return specs, primaryKeys
+}
+
+// copyRows copies the rows in batches, paged by primary key. The rows already in the table are skipped on resume.
+func (m Migrator) copyRows(tx *gorm.DB, from, to string, names, primaryKeys []string, oldColumns map[string]Column, newColumns []Column, resume bool) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	batchSize := m.rebuildBatchSize()
+	dataTypes := make(map[string]string, len(newColumns))
+	for _, c := range newColumns {
+		dataTypes[c.name] = c.datatype
+	}
+
+	selectSQL := fmt.Sprintf("SELECT %s FROM %s", strings.Join(names, ", "), from)
+	keyPos := make([]int, 0, len(primaryKeys))
+	for _, key := range primaryKeys {
+		for i, name := range names {
+			if name == key {
+				keyPos = append(keyPos, i)
+			}
+		}
+	}
+	keyset := len(primaryKeys) > 0 && len(keyPos) == len(primaryKeys)
+
+	var lastKey []interface{}
+	for {
+		query, args := selectSQL, []interface{}{}
+		if keyset {
+			if lastKey != nil {
+				query += " WHERE ?"
+				args = append(args, keysetCondition(primaryKeys, lastKey, false))
+			}
+			if len(primaryKeys) == 1 {
+				query += fmt.Sprintf(" ORDER BY %s", primaryKeys[0])
+			}
+			// without ORDER BY the rows are read in the order of the primary index, composite keys included
+			query += fmt.Sprintf(" LIMIT %d", batchSize)
+		}
+
+		rows, err := tx.Raw(query, args...).Rows()
+		if err != nil {
+			return err
+		}
+		var batch [][]interface{}
+		for rows.Next() {
+			values := make([]interface{}, len(names))
+			ptrs := make([]interface{}, len(names))
+			for i := range values {
+				ptrs[i] = &values[i]
+			}
+			if err = rows.Scan(ptrs...); err != nil {
+				rows.Close()
+				return err
+			}
+			for i, name := range names {
+				if oldColumns[name].datatype != dataTypes[name] {
+					if values[i], err = convertColumnValue(values[i], dataTypes[name]); err != nil {
+						rows.Close()
+						return fmt.Errorf("column %s: %w", name, err)
+					}
+				}
+			}
+			batch = append(batch, values)
+		}
+		rows.Close()
+
+		for start := 0; start < len(batch); start += batchSize {
+			end := start + batchSize
+			if end > len(batch) {
+				end = len(batch)
+			}
+			if err = insertRows(tx, to, names, batch[start:end], resume); err != nil {
+				return err
+			}
+		}
+
+		if !keyset || len(batch) < batchSize {
+			return nil
+		}
+		last := batch[len(batch)-1]
+		lastKey = make([]interface{}, len(keyPos))
+		for i, pos := range keyPos {
+			lastKey[i] = last[pos]
+		}
+	}
+}
+
+func insertRows(tx *gorm.DB, table string, names []string, rows [][]interface{}, resume bool) error {
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?,", len(names)), ",") + ")"
+	if resume {
+		// immudb stops at the first conflicting row of an INSERT, so the rows are inserted one by one
+		insertSQL := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON CONFLICT DO NOTHING", table, strings.Join(names, ", "), placeholders)
+		return tx.Transaction(func(tx *gorm.DB) error {
+			for _, row := range rows {
+				if err := tx.Exec(insertSQL, row...).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	values := make([]string, len(rows))
+	vars := make([]interface{}, 0, len(rows)*len(names))
+	for i, row := range rows {
+		values[i] = placeholders
+		vars = append(vars, row...)
+	}
+	insertSQL := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, strings.Join(names, ", "), strings.Join(values, ", "))
+
+	return tx.Transaction(func(tx *gorm.DB) error {
+		return tx.Exec(insertSQL, vars...).Error
+	})
+}
+
+func (m Migrator) rebuildBatchSize() int {
+	if dialector, ok := m.Dialector.(*Dialector); ok && dialector.cfg.RebuildBatchSize > 0 {
+		return dialector.cfg.RebuildBatchSize
+	}
+	return defaultRebuildBatchSize
+}
+
+// convertColumnValue converts a value read from a column to the type of the rebuilt column
+func convertColumnValue(v interface{}, dataType string) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch dataType {
+	case "VARCHAR":
+		switch t := v.(type) {
+		case []byte:
+			return string(t), nil
+		case time.Time:
+			return t.Format(time.RFC3339Nano), nil
+		default:
+			return fmt.Sprint(t), nil
+		}
+	case "INTEGER":
+		switch t := v.(type) {
+		case int64:
+			return t, nil
+		case bool:
+			if t {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case string:
+			return strconv.ParseInt(strings.TrimSpace(t), 10, 64)
+		}
+	case "BOOLEAN":
+		switch t := v.(type) {
+		case bool:
+			return t, nil
+		case int64:
+			return t != 0, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(t))
+		}
+	case "BLOB":
+		switch t := v.(type) {
+		case []byte:
+			return t, nil
+		case string:
+			return []byte(t), nil
+		}
+	case "TIMESTAMP":
+		switch t := v.(type) {
+		case time.Time:
+			return t, nil
+		case string:
+			return time.Parse(time.RFC3339Nano, strings.TrimSpace(t))
+		}
+	}
+	return nil, fmt.Errorf("%w: cannot convert %T to %s", ErrUnsupportedSchemaChange, v, dataType)
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"gorm.io/gorm"
+	"io"
+)
+
+// The rows computed on the client, like the groups of aggregated queries, are returned by an in memory database,
+// as gorm scans them from *sql.Rows. It also streams the rows of queries with an offset, skipping the first ones.
+
+var resultsDB = sql.OpenDB(resultConnector{})
+
+// resultRows are rows computed on the client
+type resultRows struct {
+	columns []string
+	// types are the database types of the columns, if known
+	types  []string
+	values [][]driver.Value
+}
+
+// resultConnPool returns the rows computed for the query sql, or the rows of the query following the first offset
+// ones, passing the other queries to the pool
+type resultConnPool struct {
+	gorm.ConnPool
+	sql    string
+	rows   *resultRows
+	offset int
+}
+
+// result returns the argument of the query of resultsDB returning the rows
+func (p *resultConnPool) result(ctx context.Context, args []interface{}) interface{} {
+	if p.rows != nil {
+		return p.rows
+	}
+	rows, err := p.ConnPool.QueryContext(ctx, p.sql, args...)
+	if err != nil {
+		return err
+	}
+	return &offsetRows{rows: rows, offset: p.offset}
+}
+
+func (p *resultConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if query != p.sql {
+		return p.ConnPool.QueryContext(ctx, query, args...)
+	}
+	return resultsDB.QueryContext(ctx, "", p.result(ctx, args))
+}
+
+func (p *resultConnPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if query != p.sql {
+		return p.ConnPool.QueryRowContext(ctx, query, args...)
+	}
+	return resultsDB.QueryRowContext(ctx, "", p.result(ctx, args))
+}
+
+// restoreConnPool restores the pool of the statement once the computed rows are returned
+func (dialector *Dialector) restoreConnPool(db *gorm.DB) {
+	if p, ok := db.Statement.ConnPool.(*resultConnPool); ok {
+		db.Statement.ConnPool = p.ConnPool
+	}
+}
+
+type resultConnector struct{}
+
+func (c resultConnector) Connect(context.Context) (driver.Conn, error) {
+	return resultConn{}, nil
+}
+
+func (c resultConnector) Driver() driver.Driver {
+	return resultDriver{}
+}
+
+type resultDriver struct{}
+
+func (d resultDriver) Open(string) (driver.Conn, error) {
+	return resultConn{}, nil
+}
+
+// resultConn returns the rows passed as the only argument of the queries
+type resultConn struct{}
+
+func (c resultConn) Prepare(string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c resultConn) Close() error {
+	return nil
+}
+
+func (c resultConn) Begin() (driver.Tx, error) {
+	return nil, ErrNotImplemented
+}
+
+func (c resultConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (c resultConn) QueryContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Rows, error) {
+	switch v := args[0].Value.(type) {
+	case *resultRows:
+		return &resultCursor{rows: v}, nil
+	case *offsetRows:
+		return v, v.init()
+	case error:
+		return nil, v
+	}
+	return nil, ErrNotImplemented
+}
+
+type resultCursor struct {
+	rows *resultRows
+	pos  int
+}
+
+func (c *resultCursor) Columns() []string {
+	return c.rows.columns
+}
+
+func (c *resultCursor) ColumnTypeDatabaseTypeName(index int) string {
+	if index < len(c.rows.types) {
+		return c.rows.types[index]
+	}
+	return ""
+}
+
+func (c *resultCursor) Close() error {
+	return nil
+}
+
+func (c *resultCursor) Next(dest []driver.Value) error {
+	if c.pos >= len(c.rows.values) {
+		return io.EOF
+	}
+	copy(dest, c.rows.values[c.pos])
+	c.pos++
+	return nil
+}
+
+// offsetRows streams the rows of a query following the first offset ones
+type offsetRows struct {
+	rows    *sql.Rows
+	offset  int
+	columns []string
+	types   []*sql.ColumnType
+	values  []interface{}
+	ptrs    []interface{}
+}
+
+func (r *offsetRows) init() (err error) {
+	defer func() {
+		if err != nil {
+			r.rows.Close()
+		}
+	}()
+	if r.columns, err = r.rows.Columns(); err != nil {
+		return err
+	}
+	if r.types, err = r.rows.ColumnTypes(); err != nil {
+		return err
+	}
+	r.values = make([]interface{}, len(r.columns))
+	r.ptrs = make([]interface{}, len(r.columns))
+	for i := range r.values {
+		r.ptrs[i] = &r.values[i]
+	}
+	return nil
+}
+
+func (r *offsetRows) Columns() []string {
+	return r.columns
+}
+
+func (r *offsetRows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.types[index].DatabaseTypeName()
+}
+
+func (r *offsetRows) Close() error {
+	return r.rows.Close()
+}
+
+func (r *offsetRows) Next(dest []driver.Value) error {
+	for ; r.offset > 0; r.offset-- {
+		if !r.rows.Next() {
+			break
+		}
+	}
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	if err := r.rows.Scan(r.ptrs...); err != nil {
+		return err
+	}
+	for i, v := range r.values {
+		dest[i] = v
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"context"
+	"database/sql/driver"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+// immudb has no JSON type. The data type gorm gives to serialized fields comes from the kind of the field, while
+// the column has to hold what the serializer produces: strings for json, bytes for gob and timestamps for unixtime.
+
+// serializedDataType returns the data type of the values produced by the serializer of the field
+func serializedDataType(field *schema.Field) schema.DataType {
+	switch field.Serializer.(type) {
+	case schema.JSONSerializer, *schema.JSONSerializer:
+		return schema.String
+	case schema.GobSerializer, *schema.GobSerializer:
+		return schema.Bytes
+	case schema.UnixSecondSerializer, *schema.UnixSecondSerializer:
+		return schema.Time
+	}
+	return field.DataType
+}
+
+// serializedValue serializes the value of a field when it is sent to immudb
+type serializedValue struct {
+	ctx    context.Context
+	field  *schema.Field
+	dst    reflect.Value
+	valuer schema.SerializerValuerInterface
+	value  interface{}
+}
+
+func (v serializedValue) Value() (driver.Value, error) {
+	return v.valuer.Value(v.ctx, v.field, v.dst, v.value)
+}
+
+// serializeZeroValues makes the field serialize zero values too. gorm sends them unserialized, as they are, and
+// immudb rejects maps, slices and structs.
+func serializeZeroValues(field *schema.Field) {
+	valueOf := field.ValueOf
+	field.ValueOf = func(ctx context.Context, rv reflect.Value) (interface{}, bool) {
+		value, zero := valueOf(ctx, rv)
+		if !zero {
+			return value, zero
+		}
+		return newSerializedValue(ctx, field, rv, value), zero
+	}
+}
+
+func newSerializedValue(ctx context.Context, field *schema.Field, dst reflect.Value, value interface{}) serializedValue {
+	valuer, ok := value.(schema.SerializerValuerInterface)
+	if !ok {
+		valuer = field.Serializer
+	}
+	return serializedValue{ctx: ctx, field: field, dst: dst, valuer: valuer, value: value}
+}
+
+// serializeMapValues serializes the values of map statements, gorm sends them as they are. The values of large
+// object fields are replaced by their reference, the ones of encrypted fields by their ciphertext. The fields of the
+// model are assigned first, as gorm cannot assign the serialized values.
+func (dialector *Dialector) serializeMapValues(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+
+	switch dest := db.Statement.Dest.(type) {
+	case map[string]interface{}:
+		db.Statement.Dest = dialector.serializedMap(db.Statement, dest)
+	case []map[string]interface{}:
+		rows := make([]map[string]interface{}, len(dest))
+		for i, m := range dest {
+			rows[i] = dialector.serializedMap(db.Statement, m)
+		}
+		db.Statement.Dest = rows
+	}
+}
+
+// serializedMap returns a copy of m holding the serialized values of the serialized fields
+func (dialector *Dialector) serializedMap(stmt *gorm.Statement, m map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		values[k] = v
+		field := stmt.Schema.LookUpField(k)
+		if field == nil || v == nil {
+			continue
+		}
+		c, encrypted := dialector.cipherOf(field)
+		if field.Serializer == nil && !isLobField(field) && !encrypted {
+			continue
+		}
+		if _, ok := v.(clause.Expression); ok {
+			continue
+		}
+
+		if stmt.ReflectValue.Kind() == reflect.Struct && stmt.ReflectValue.CanAddr() {
+			_ = field.Set(stmt.Context, stmt.ReflectValue, v)
+		}
+		if field.Serializer != nil {
+			values[k] = newSerializedValue(stmt.Context, field, stmt.ReflectValue, v)
+		} else if encrypted {
+			values[k] = c.valueOf(v)
+		} else if data, ok := lobData(v); ok {
+			values[k] = lobValue{ref: newLobReference(data)}
+		}
+	}
+	return values
+}
@@ -0,0 +1,478 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"database/sql"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"regexp"
+	"strings"
+)
+
+// immudb runs the subqueries read as tables, like db.Table("(?) AS t", sub), but not the ones of conditions, like
+// IN (SELECT ...). The subqueries of conditions are run first and replaced by the list of values they return, which
+// is bounded by the subquery limit. Subqueries referencing the outer query cannot run on their own and are reported.
+
+const (
+	defaultSubqueryLimit = 10000
+	// serverOnlySetting makes the statement fail instead of being emulated on the client, see onServerOnly
+	serverOnlySetting = "immudb:server_only"
+)
+
+func (dialector *Dialector) subqueryLimit() int {
+	if dialector.cfg != nil && dialector.cfg.SubqueryLimit != 0 {
+		return dialector.cfg.SubqueryLimit
+	}
+	return defaultSubqueryLimit
+}
+
+// onServerOnly reports the parts of subqueries read as tables that would be emulated on the client, where immudb
+// runs them as part of the outer query
+func onServerOnly(db *gorm.DB, part string) bool {
+	if _, ok := db.Statement.Settings.Load(serverOnlySetting); !ok {
+		return false
+	}
+	db.AddError(fmt.Errorf("%w: %s of a subquery read as a table cannot be emulated on the client", ErrUnsupportedSubquery, part))
+	return true
+}
+
+// inlineSubqueries replaces the subqueries of the conditions of the statement with the values they return, and
+// checks that immudb is able to run the subqueries read as tables
+func (dialector *Dialector) inlineSubqueries(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.SQL.Len() > 0 || db.DryRun {
+		return
+	}
+
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok {
+			exprs, err := dialector.inlineExprs(db, where.Exprs)
+			if err != nil {
+				db.AddError(err)
+				return
+			}
+			c.Expression = clause.Where{Exprs: exprs}
+			stmt.Clauses["WHERE"] = c
+		}
+	}
+
+	if stmt.TableExpr != nil {
+		vars, err := dialector.prepareTableSubqueries(db, stmt.TableExpr.Vars)
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		tableExpr := *stmt.TableExpr
+		tableExpr.Vars = vars
+		stmt.TableExpr = &tableExpr
+	}
+	if len(stmt.Joins) > 0 {
+		joins := append(stmt.Joins[:0:0], stmt.Joins...)
+		for idx := range joins {
+			vars, err := dialector.prepareTableSubqueries(db, joins[idx].Conds)
+			if err != nil {
+				db.AddError(err)
+				return
+			}
+			joins[idx].Conds = vars
+		}
+		stmt.Joins = joins
+	}
+}
+
+// inlineExpr replaces the subqueries of a condition with the values they return
+func (dialector *Dialector) inlineExpr(db *gorm.DB, expr clause.Expression) (clause.Expression, error) {
+	var err error
+	switch v := expr.(type) {
+	case clause.Expr:
+		v.Vars, err = dialector.inlineVars(db, v.SQL, v.Vars)
+		return v, err
+	case clause.NamedExpr:
+		v.Vars, err = dialector.inlineVars(db, v.SQL, v.Vars)
+		return v, err
+	case clause.IN:
+		values := make([]interface{}, 0, len(v.Values))
+		for _, value := range v.Values {
+			sub, ok := value.(*gorm.DB)
+			if !ok {
+				values = append(values, value)
+				continue
+			}
+			inlined, err := dialector.subqueryValues(db, sub)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, inlined...)
+		}
+		v.Values = values
+		return v, nil
+	case clause.Eq:
+		return dialector.inlineComparison(db, v, v.Value, func(value interface{}) clause.Expression {
+			v.Value = value
+			return v
+		})
+	case clause.Neq:
+		return dialector.inlineComparison(db, v, v.Value, func(value interface{}) clause.Expression {
+			v.Value = value
+			return v
+		})
+	case clause.Gt:
+		return dialector.inlineComparison(db, v, v.Value, func(value interface{}) clause.Expression {
+			v.Value = value
+			return v
+		})
+	case clause.Gte:
+		return dialector.inlineComparison(db, v, v.Value, func(value interface{}) clause.Expression {
+			v.Value = value
+			return v
+		})
+	case clause.Lt:
+		return dialector.inlineComparison(db, v, v.Value, func(value interface{}) clause.Expression {
+			v.Value = value
+			return v
+		})
+	case clause.Lte:
+		return dialector.inlineComparison(db, v, v.Value, func(value interface{}) clause.Expression {
+			v.Value = value
+			return v
+		})
+	case clause.AndConditions:
+		v.Exprs, err = dialector.inlineExprs(db, v.Exprs)
+		return v, err
+	case clause.OrConditions:
+		v.Exprs, err = dialector.inlineExprs(db, v.Exprs)
+		return v, err
+	case clause.NotConditions:
+		v.Exprs, err = dialector.inlineExprs(db, v.Exprs)
+		return v, err
+	}
+	return expr, nil
+}
+
+func (dialector *Dialector) inlineExprs(db *gorm.DB, exprs []clause.Expression) ([]clause.Expression, error) {
+	inlined := make([]clause.Expression, len(exprs))
+	for idx, expr := range exprs {
+		var err error
+		if inlined[idx], err = dialector.inlineExpr(db, expr); err != nil {
+			return nil, err
+		}
+	}
+	return inlined, nil
+}
+
+// inlineComparison replaces a subquery compared with a column with the single value it returns. The comparison
+// with a subquery returning no rows, or NULL, is false.
+func (dialector *Dialector) inlineComparison(db *gorm.DB, expr clause.Expression, value interface{}, with func(interface{}) clause.Expression) (clause.Expression, error) {
+	sub, ok := value.(*gorm.DB)
+	if !ok {
+		return expr, nil
+	}
+	value, err := dialector.inlineVar(db, "=", sub)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return clause.Expr{SQL: "FALSE"}, nil
+	}
+	return with(value), nil
+}
+
+// inlineVars replaces the subqueries of the arguments of a SQL condition with the values they return: the list of
+// values, which gorm expands in IN (?) and IN ? conditions, or the single value of comparisons. The values of named
+// arguments are replaced too.
+func (dialector *Dialector) inlineVars(db *gorm.DB, query string, vars []interface{}) ([]interface{}, error) {
+	inlined := make([]interface{}, len(vars))
+	position := 0
+	for idx, v := range vars {
+		var err error
+		switch value := v.(type) {
+		case sql.NamedArg:
+			if sub, ok := value.Value.(*gorm.DB); ok {
+				value.Value, err = dialector.inlineVar(db, argumentOperator(query, -1, value.Name), sub)
+				v = value
+			}
+		case map[string]interface{}:
+			named := make(map[string]interface{}, len(value))
+			for name, arg := range value {
+				if sub, ok := arg.(*gorm.DB); ok && err == nil {
+					arg, err = dialector.inlineVar(db, argumentOperator(query, -1, name), sub)
+				}
+				named[name] = arg
+			}
+			v = named
+		case *gorm.DB:
+			v, err = dialector.inlineVar(db, argumentOperator(query, position, ""), value)
+			position++
+		default:
+			position++
+		}
+		if err != nil {
+			return nil, err
+		}
+		inlined[idx] = v
+	}
+	return inlined, nil
+}
+
+var operatorRegexp = regexp.MustCompile(`(?i)(\bIN|\bEXISTS|[=<>])[\s(]*$`)
+
+// argumentOperator returns the upper case operator preceding the argument of a SQL condition, the ? at position or
+// @name, which is empty if unknown
+func argumentOperator(query string, position int, name string) string {
+	end := -1
+	if name != "" {
+		end = strings.Index(query, "@"+name)
+	} else {
+		for i := 0; i < len(query); i++ {
+			if query[i] == '?' {
+				if position == 0 {
+					end = i
+					break
+				}
+				position--
+			}
+		}
+	}
+	if end < 0 {
+		return ""
+	}
+	if match := operatorRegexp.FindStringSubmatch(query[:end]); match != nil {
+		return strings.ToUpper(match[1])
+	}
+	return ""
+}
+
+// inlineVar returns the values of a subquery compared with the operator, the single value of comparisons
+func (dialector *Dialector) inlineVar(db *gorm.DB, operator string, sub *gorm.DB) (interface{}, error) {
+	switch operator {
+	case "EXISTS":
+		return nil, fmt.Errorf("%w: EXISTS conditions cannot be emulated, use IN instead", ErrUnsupportedSubquery)
+	case "=", "<", ">":
+		values, err := dialector.subqueryValues(db, sub)
+		switch {
+		case err != nil:
+			return nil, err
+		case len(values) > 1:
+			return nil, fmt.Errorf("%w: a subquery compared with a column returned %d rows", ErrUnsupportedSubquery, len(values))
+		case len(values) == 0:
+			return nil, nil
+		}
+		return values[0], nil
+	}
+	return dialector.subqueryValues(db, sub)
+}
+
+// tableExprRegexp matches a table expression naming a table and its alias, like db.Table("lines l") does
+var tableExprRegexp = regexp.MustCompile(`(?i)^\s*(\w+)(?:\s+(?:AS\s+)?(\w+))?\s*$`)
+
+// queryTable is the table a statement reads and the alias it gives to it
+type queryTable struct {
+	name  string
+	alias string
+}
+
+// statementTable returns the table of the statement, with its alias when it is set through a table expression
+func statementTable(stmt *gorm.Statement) queryTable {
+	if stmt.TableExpr != nil && len(stmt.TableExpr.Vars) == 0 {
+		if m := tableExprRegexp.FindStringSubmatch(stmt.TableExpr.SQL); m != nil {
+			return queryTable{name: m[1], alias: m[2]}
+		}
+	}
+	return queryTable{name: stmt.Table}
+}
+
+// ref returns the name the columns of the table are qualified with in the query
+func (t queryTable) ref() string {
+	if t.alias != "" {
+		return strings.ToLower(t.alias)
+	}
+	return strings.ToLower(t.name)
+}
+
+func (t queryTable) String() string {
+	if t.alias != "" {
+		return t.name + " " + t.alias
+	}
+	return t.name
+}
+
+// subqueryValues runs a subquery of a condition of the statement of db and returns the values of its single column
+func (dialector *Dialector) subqueryValues(db *gorm.DB, sub *gorm.DB) ([]interface{}, error) {
+	tx := sub.Session(&gorm.Session{Initialized: true, Context: db.Statement.Context})
+	if tx.Statement.Table == "" && tx.Statement.Model != nil {
+		if err := tx.Statement.Parse(tx.Statement.Model); err != nil {
+			return nil, err
+		}
+	}
+	outer, inner := statementTable(db.Statement), statementTable(tx.Statement)
+	if outer.ref() != "" && outer.ref() != inner.ref() && referencedTables(tx)[outer.ref()] {
+		return nil, fmt.Errorf("%w: the subquery on table %s references the table %s of the outer query, correlated subqueries cannot run on their own", ErrUnsupportedSubquery, inner, outer)
+	}
+	if column, err := outerColumn(db, tx, outer, inner); err != nil {
+		return nil, err
+	} else if column != "" {
+		return nil, fmt.Errorf("%w: the subquery on table %s references the column %s of the table %s of the outer query, correlated subqueries cannot run on their own", ErrUnsupportedSubquery, inner, column, outer)
+	}
+
+	limit := dialector.subqueryLimit()
+	if limit < 0 {
+		return nil, fmt.Errorf("%w: the subqueries of conditions are run on the client, which is disabled", ErrUnsupportedSubquery)
+	}
+	rows, err := tx.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) > 1 {
+		return nil, fmt.Errorf("%w: the subquery on table %s returns %d columns instead of one", ErrUnsupportedSubquery, inner, len(columns))
+	}
+	values := []interface{}{}
+	for rows.Next() {
+		if len(values) == limit {
+			return nil, fmt.Errorf("%w: the subquery on table %s returns more than %d rows", ErrUnsupportedSubquery, inner, limit)
+		}
+		var value interface{}
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// outerColumn returns a column the subquery of tx names without its table which is not a column of the table of the
+// subquery but one of the table of the outer query of db. The columns of joined tables are unknown, so the names of
+// subqueries with joins are not resolved.
+func outerColumn(db, tx *gorm.DB, outer, inner queryTable) (string, error) {
+	if outer.name == "" || inner.name == "" || len(tx.Statement.Joins) > 0 {
+		return "", nil
+	}
+	names := unqualifiedNames(clausesSQL(tx, "WHERE", "GROUP BY", "ORDER BY"))
+	if len(names) == 0 {
+		return "", nil
+	}
+
+	innerColumns, err := tableColumns(tx, inner.name)
+	if err != nil {
+		return "", err
+	}
+	outerColumns, err := tableColumns(db, outer.name)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if !innerColumns[name] && outerColumns[name] {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// tableColumns returns the lower case names of the columns of table, from the schema of the statement of db when it
+// is the one of the table
+func tableColumns(db *gorm.DB, table string) (map[string]bool, error) {
+	columns := map[string]bool{}
+	if s := db.Statement.Schema; s != nil && strings.EqualFold(s.Table, table) {
+		for _, name := range s.DBNames {
+			columns[strings.ToLower(name)] = true
+		}
+		return columns, nil
+	}
+
+	columnTypes, err := db.Session(&gorm.Session{NewDB: true}).Migrator().ColumnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range columnTypes {
+		columns[strings.ToLower(c.Name())] = true
+	}
+	return columns, nil
+}
+
+// unqualifiedNames returns the names of sql that are neither qualified, nor qualifying, nor called, nor quoted. The
+// names of nested subqueries are their own.
+func unqualifiedNames(sql string) []string {
+	var names []string
+	for i := 0; i < len(sql); {
+		switch c := sql[i]; {
+		case c == '(' && strings.HasPrefix(strings.TrimLeft(sql[i+1:], " "), "select"):
+			depth := 0
+			for ; i < len(sql); i++ {
+				if sql[i] == '(' {
+					depth++
+				} else if sql[i] == ')' {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+			i++
+		case c == '\'':
+			end := strings.IndexByte(sql[i+1:], '\'')
+			if end < 0 {
+				return names
+			}
+			i += end + 2
+		case isNameByte(c):
+			start := i
+			for i < len(sql) && isNameByte(sql[i]) {
+				i++
+			}
+			qualified := start > 0 && sql[start-1] == '.'
+			followed := i < len(sql) && (sql[i] == '.' || sql[i] == '(')
+			if !qualified && !followed && (c < '0' || c > '9') && (start == 0 || sql[start-1] != '$') {
+				names = append(names, sql[start:i])
+			}
+		default:
+			i++
+		}
+	}
+	return names
+}
+
+// prepareTableSubqueries builds the subqueries read as tables among vars, which are sent to immudb as they are. The
+// subqueries of their conditions are inlined first.
+func (dialector *Dialector) prepareTableSubqueries(db *gorm.DB, vars []interface{}) ([]interface{}, error) {
+	prepared := make([]interface{}, len(vars))
+	for idx, v := range vars {
+		prepared[idx] = v
+		sub, ok := v.(*gorm.DB)
+		if !ok || sub.Statement.SQL.Len() > 0 {
+			continue
+		}
+
+		tx := sub.Session(&gorm.Session{Initialized: true, Context: db.Statement.Context})
+		dialector.inlineSubqueries(tx)
+		if tx.Error != nil {
+			return nil, tx.Error
+		}
+		tx = tx.Session(&gorm.Session{DryRun: true})
+		tx.Statement.Settings.Store(serverOnlySetting, true)
+		if err := tx.Callback().Query().Execute(tx).Error; err != nil {
+			return nil, err
+		}
+		prepared[idx] = tx
+	}
+	return prepared, nil
+}
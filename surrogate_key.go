@@ -0,0 +1,172 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+// SurrogateKeyColumn is the hidden auto increment primary key added to the tables of models without a primary key
+const SurrogateKeyColumn = "_id"
+
+func (dialector *Dialector) hasSurrogateKey(s *schema.Schema) bool {
+	return dialector.cfg != nil && dialector.cfg.SurrogateKey && s != nil && len(s.PrimaryFields) == 0
+}
+
+func (m Migrator) hasSurrogateKey(stmt *gorm.Statement) bool {
+	dialector, ok := m.Dialector.(*Dialector)
+	return ok && dialector.hasSurrogateKey(stmt.Schema)
+}
+
+// useSurrogateKeyOnUpdate targets the records of the model with their surrogate key
+func (dialector *Dialector) useSurrogateKeyOnUpdate(db *gorm.DB) {
+	// a full save carries the new values only, the stored record cannot be found
+	if db.Statement.Dest != db.Statement.Model {
+		dialector.useSurrogateKey(db)
+	}
+}
+
+// useSurrogateKey adds a condition on the surrogate key to update or delete statements without conditions.
+// The keys are found matching the stored records against the values of the model, that is expected to hold
+// the records as they were read. A record equal to several stored records cannot tell them apart, and a record equal
+// to none is not stored, so both are errors.
+func (dialector *Dialector) useSurrogateKey(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || !dialector.hasSurrogateKey(stmt.Schema) {
+		return
+	}
+	if _, ok := stmt.Clauses["WHERE"]; ok {
+		return
+	}
+
+	var records []reflect.Value
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			records = append(records, reflect.Indirect(stmt.ReflectValue.Index(i)))
+		}
+	case reflect.Struct:
+		records = append(records, stmt.ReflectValue)
+	}
+
+	var (
+		keys  []interface{}
+		found = map[int64]bool{}
+		tx    = db.Session(&gorm.Session{NewDB: true})
+	)
+	for _, record := range records {
+		exprs, ok := dialector.recordConditions(stmt.Context, stmt.Schema, record)
+		if !ok {
+			continue
+		}
+
+		var ids []int64
+		err := tx.Table(stmt.Schema.Table).Where(clause.Where{Exprs: exprs}).Limit(2).Pluck(SurrogateKeyColumn, &ids).Error
+		if db.AddError(err) != nil {
+			return
+		}
+		switch {
+		case len(ids) > 1:
+			db.AddError(fmt.Errorf("%w: use conditions to select the records of %s", ErrAmbiguousRecord, stmt.Schema.Table))
+			return
+		case len(ids) == 0:
+			db.AddError(fmt.Errorf("%w: no stored record of %s equals the record", gorm.ErrRecordNotFound, stmt.Schema.Table))
+			return
+		case !found[ids[0]]:
+			found[ids[0]] = true
+			keys = append(keys, ids[0])
+		}
+	}
+
+	if len(keys) == 0 {
+		// without records gorm refuses the statement
+		return
+	}
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.IN{Column: clause.Column{Table: clause.CurrentTable, Name: SurrogateKeyColumn}, Values: keys},
+	}})
+}
+
+// recordConditions matches every column with the value of the record, as it is stored. Floats are compared with their
+// encoding, while the columns encrypted with a random nonce never match and are left out. Records holding only zero
+// values are skipped.
+func (dialector *Dialector) recordConditions(ctx context.Context, s *schema.Schema, record reflect.Value) ([]clause.Expression, bool) {
+	if record.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	var (
+		exprs   = make([]clause.Expression, 0, len(s.DBNames))
+		notZero bool
+	)
+	for _, dbName := range s.DBNames {
+		field := s.FieldsByDBName[dbName]
+		if field.IgnoreMigration {
+			continue
+		}
+		value, zero := field.ValueOf(ctx, record)
+		notZero = notZero || !zero
+		if isEncryptedField(field) {
+			if fc, ok := dialector.cipherOf(field); !ok || !fc.deterministic {
+				continue
+			}
+		}
+
+		rv := reflect.ValueOf(value)
+		for rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				value = nil
+				break
+			}
+			rv = rv.Elem()
+			value = rv.Interface()
+		}
+		if isFloatColumn(field) {
+			value, _ = encodeFloatVar(value)
+		}
+		exprs = append(exprs, clause.Eq{Column: clause.Column{Name: dbName}, Value: value})
+	}
+	return exprs, notZero
+}
+
+// orderBySurrogateKey makes First and Last order the records of models without a primary key by their surrogate key
+func (dialector *Dialector) orderBySurrogateKey(db *gorm.DB) {
+	if db.Error != nil || !dialector.hasSurrogateKey(db.Statement.Schema) {
+		return
+	}
+	c, ok := db.Statement.Clauses["ORDER BY"]
+	if !ok {
+		return
+	}
+	if orderBy, ok := c.Expression.(clause.OrderBy); ok {
+		columns := make([]clause.OrderByColumn, len(orderBy.Columns))
+		for i, column := range orderBy.Columns {
+			if column.Column.Name == clause.PrimaryKey {
+				column.Column.Name = SurrogateKeyColumn
+			}
+			columns[i] = column
+		}
+		orderBy.Columns = columns
+		c.Expression = orderBy
+		db.Statement.Clauses["ORDER BY"] = c
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"fmt"
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+	"time"
+)
+
+type Reading struct {
+	ID       uint
+	Sensor   string `gorm:"size:32;index"`
+	Value    float64
+	TakenAt  time.Time `gorm:"index"`
+	Position int
+}
+
+func TestCreateInBatches(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Reading{})
+	require.NoError(t, err)
+
+	now := time.Now()
+	newReadings := func(n int) []Reading {
+		readings := make([]Reading, n)
+		for i := range readings {
+			readings[i] = Reading{Sensor: fmt.Sprintf("sensor-%d", i%7), Value: float64(i) / 4, TakenAt: now.Add(time.Duration(i) * time.Second), Position: i}
+		}
+		return readings
+	}
+
+	// a row takes 3 entries, 341 rows fit in a transaction of 1024 entries
+	readings := newReadings(900)
+	err = db.CreateInBatches(&readings, 450).Error
+	require.ErrorIs(t, err, immugorm.ErrTooManyRows)
+	tx := immugorm.SplitBatches(db).CreateInBatches(&readings, 450)
+	require.NoError(t, tx.Error)
+	require.Equal(t, int64(900), tx.RowsAffected)
+
+	ids := map[uint]bool{}
+	for _, reading := range readings {
+		require.NotZero(t, reading.ID)
+		ids[reading.ID] = true
+	}
+	require.Len(t, ids, len(readings))
+
+	var stored []Reading
+	err = db.Order("id").Find(&stored).Error
+	require.NoError(t, err)
+	require.Len(t, stored, len(readings))
+	for i, reading := range stored {
+		require.Equal(t, readings[i].ID, reading.ID)
+		require.Equal(t, readings[i].Position, reading.Position)
+		require.Equal(t, readings[i].Value, reading.Value)
+		require.True(t, readings[i].TakenAt.Equal(reading.TakenAt))
+	}
+
+	// the rows of a single Create are committed together, they are not split
+	readings = newReadings(700)
+	err = db.Create(&readings).Error
+	require.ErrorIs(t, err, immugorm.ErrTooManyRows)
+	var count int64
+	err = db.Model(&Reading{}).Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(900), count)
+
+	// unless the configured batch size makes it run in batches
+	tx = db.Session(&gorm.Session{CreateBatchSize: 700}).Create(&readings)
+	require.NoError(t, tx.Error)
+	require.Equal(t, int64(700), tx.RowsAffected)
+	require.Equal(t, stored[len(stored)-1].ID+1, readings[0].ID)
+	require.Equal(t, readings[0].ID+699, readings[699].ID)
+
+	// with maps
+	rows := make([]map[string]interface{}, 400)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"sensor": "map", "position": i}
+	}
+	err = immugorm.SplitBatches(db.Model(&Reading{})).CreateInBatches(rows, 400).Error
+	require.NoError(t, err)
+	err = db.Model(&Reading{}).Where("sensor = ?", "map").Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(400), count)
+}
+
+func BenchmarkCreateInBatches(b *testing.B) {
+	db, close, err := OpenDB()
+	require.NoError(b, err)
+	defer close()
+	db = db.Session(&gorm.Session{Logger: logger.Discard})
+
+	err = db.AutoMigrate(&Reading{})
+	require.NoError(b, err)
+
+	for _, batchSize := range []int{1, 10, 100, 300} {
+		b.Run(fmt.Sprintf("batch size %d", batchSize), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				readings := make([]Reading, 300)
+				for i := range readings {
+					readings[i] = Reading{Sensor: "bench", Value: float64(i), TakenAt: time.Now(), Position: i}
+				}
+				err := db.CreateInBatches(&readings, batchSize).Error
+				require.NoError(b, err)
+			}
+		})
+	}
+}
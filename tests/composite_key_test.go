@@ -0,0 +1,68 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type Shipment struct {
+	OrderID   uint   `gorm:"primaryKey;autoIncrement:false"`
+	Warehouse string `gorm:"primaryKey;size:64"`
+	Quantity  uint
+}
+
+func TestCompositePrimaryKey(t *testing.T) {
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{Verify: true})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Shipment{})
+	require.NoError(t, err)
+
+	err = db.Create(&[]Shipment{
+		{OrderID: 1, Warehouse: "north", Quantity: 10},
+		{OrderID: 1, Warehouse: "south", Quantity: 20},
+		{OrderID: 2, Warehouse: "north", Quantity: 30},
+	}).Error
+	require.NoError(t, err)
+
+	var shipment Shipment
+	err = db.First(&shipment, "order_id = ? AND warehouse = ?", 1, "south").Error
+	require.NoError(t, err)
+	require.Equal(t, uint(20), shipment.Quantity)
+
+	err = db.Model(&shipment).Update("Quantity", 25).Error
+	require.NoError(t, err)
+
+	var north Shipment
+	err = db.First(&north, "order_id = ? AND warehouse = ?", 1, "north").Error
+	require.NoError(t, err)
+	require.Equal(t, uint(10), north.Quantity)
+
+	err = db.Delete(&north).Error
+	require.NoError(t, err)
+
+	var shipments []Shipment
+	err = db.Where("order_id = ?", 1).Find(&shipments).Error
+	require.NoError(t, err)
+	require.Len(t, shipments, 1)
+	require.Equal(t, "south", shipments[0].Warehouse)
+	require.Equal(t, uint(25), shipments[0].Quantity)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"testing"
+)
+
+type createCounter struct {
+	count int
+}
+
+func (p *createCounter) Name() string {
+	return "create_counter"
+}
+
+func (p *createCounter) Initialize(db *gorm.DB) error {
+	return db.Callback().Create().After("gorm:create").Register("create_counter", func(*gorm.DB) {
+		p.count++
+	})
+}
+
+func TestDatabases(t *testing.T) {
+	type Tenant struct {
+		ID   uint
+		Name string
+	}
+
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	require.Equal(t, "defaultdb", db.Migrator().CurrentDatabase())
+
+	m := db.Migrator().(immugorm.Migrator)
+	require.False(t, m.HasDatabase("tenant1"))
+
+	err = m.CreateDatabase("tenant1")
+	require.NoError(t, err)
+	require.True(t, m.HasDatabase("tenant1"))
+
+	names, err := m.ListDatabases()
+	require.NoError(t, err)
+	require.Contains(t, names, "defaultdb")
+	require.Contains(t, names, "tenant1")
+
+	counter := &createCounter{}
+	require.NoError(t, db.Use(counter))
+	db.Config.CreateBatchSize = 10
+	db.Config.DisableAutomaticPing = true
+
+	tenantDB, err := immugorm.UseDatabase(db, "tenant1")
+	require.NoError(t, err)
+	require.Equal(t, "tenant1", tenantDB.Migrator().CurrentDatabase())
+	require.Equal(t, 10, tenantDB.Config.CreateBatchSize)
+	require.True(t, tenantDB.Config.DisableAutomaticPing)
+
+	err = tenantDB.AutoMigrate(&Tenant{})
+	require.NoError(t, err)
+	err = tenantDB.Create(&Tenant{Name: "acme"}).Error
+	require.NoError(t, err)
+
+	require.Equal(t, 1, counter.count)
+
+	require.True(t, tenantDB.Migrator().HasTable(&Tenant{}))
+	require.False(t, db.Migrator().HasTable(&Tenant{}))
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDefaultValues(t *testing.T) {
+	type Task struct {
+		ID        uint
+		Status    string    `gorm:"size:32;default:'open'"`
+		Priority  int       `gorm:"default:5"`
+		Active    bool      `gorm:"default:true"`
+		Deadline  time.Time `gorm:"default:'2030-01-01 00:00:00'"`
+		CreatedOn time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	}
+
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Task{})
+	require.NoError(t, err)
+
+	before := time.Now()
+	task := Task{}
+	err = db.Create(&task).Error
+	require.NoError(t, err)
+	require.Equal(t, "open", task.Status)
+	require.False(t, task.CreatedOn.IsZero())
+
+	tasks := []Task{{Priority: 1}, {Status: "closed"}}
+	err = db.Create(&tasks).Error
+	require.NoError(t, err)
+
+	var stored Task
+	err = db.First(&stored, task.ID).Error
+	require.NoError(t, err)
+	require.Equal(t, "open", stored.Status)
+	require.Equal(t, 5, stored.Priority)
+	require.True(t, stored.Active)
+	require.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), stored.Deadline.UTC())
+	require.WithinDuration(t, before, stored.CreatedOn, time.Minute)
+
+	var first Task
+	err = db.First(&first, "priority = ?", 1).Error
+	require.NoError(t, err)
+	require.Equal(t, "open", first.Status)
+	require.False(t, first.CreatedOn.IsZero())
+
+	var second Task
+	err = db.First(&second, "status = ?", "closed").Error
+	require.NoError(t, err)
+	require.Equal(t, 5, second.Priority)
+	require.True(t, second.Active)
+
+	columnTypes, err := db.Migrator().ColumnTypes(&Task{})
+	require.NoError(t, err)
+	defaults := map[string]string{}
+	for _, c := range columnTypes {
+		if d, ok := c.(interface{ DefaultValue() (string, bool) }); ok {
+			if v, ok := d.DefaultValue(); ok {
+				defaults[c.Name()] = v
+			}
+		}
+	}
+	require.Equal(t, map[string]string{
+		"status":     "open",
+		"priority":   "5",
+		"active":     "true",
+		"deadline":   "'2030-01-01 00:00:00'",
+		"created_on": "CURRENT_TIMESTAMP",
+	}, defaults)
+}
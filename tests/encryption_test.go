@@ -0,0 +1,158 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"bytes"
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type Patient struct {
+	ID       uint
+	Name     string  `gorm:"size:64"`
+	SSN      string  `gorm:"size:32;uniqueIndex" immudb:"encrypted"`
+	Notes    string  `immudb:"encrypted"`
+	Scan     []byte  `immudb:"encrypted"`
+	Nickname *string `gorm:"size:16" immudb:"encrypted,deterministic"`
+}
+
+func TestEncryption(t *testing.T) {
+	opts, close := StartServer()
+	defer close()
+
+	key := immugorm.StaticKey(bytes.Repeat([]byte{0x42}, 32))
+	db, err := Open(opts, &immugorm.ImmuGormConfig{Verify: true, KeyProvider: key})
+	require.NoError(t, err)
+
+	err = db.AutoMigrate(&Patient{})
+	require.NoError(t, err)
+
+	nickname := "jd"
+	patients := []Patient{
+		{Name: "John", SSN: "123-45-6789", Notes: "allergic to penicillin", Scan: []byte{0, 1, 2}, Nickname: &nickname},
+		{Name: "Jane", SSN: "987-65-4321", Notes: "allergic to penicillin"},
+	}
+	for i := range patients {
+		err = db.Create(&patients[i]).Error
+		require.NoError(t, err)
+	}
+
+	// verification runs over the stored ciphertext
+	var stored []Patient
+	err = db.Order("id").Find(&stored).Error
+	require.NoError(t, err)
+	require.Equal(t, patients, stored)
+
+	var notes [][]byte
+	err = db.Table("patients").Order("id").Pluck("notes", &notes).Error
+	require.NoError(t, err)
+	require.Len(t, notes, 2)
+	require.NotContains(t, string(notes[0]), "penicillin")
+	require.NotEqual(t, notes[0], notes[1])
+
+	// indexed and deterministic columns are looked up by equality
+	var found Patient
+	err = db.Where(&Patient{SSN: "987-65-4321"}).First(&found).Error
+	require.NoError(t, err)
+	require.Equal(t, patients[1], found)
+
+	found = Patient{}
+	err = db.Where(map[string]interface{}{"nickname": "jd"}).First(&found).Error
+	require.NoError(t, err)
+	require.Equal(t, patients[0].ID, found.ID)
+
+	var ids []uint
+	err = db.Model(&Patient{}).Where(map[string]interface{}{"ssn": []string{"000-00-0000", "123-45-6789"}}).Pluck("id", &ids).Error
+	require.NoError(t, err)
+	require.Equal(t, []uint{patients[0].ID}, ids)
+
+	err = db.Model(&found).Updates(map[string]interface{}{"notes": "no allergies", "scan": []byte{3}}).Error
+	require.NoError(t, err)
+	require.Equal(t, "no allergies", found.Notes)
+
+	var updated Patient
+	err = db.First(&updated, found.ID).Error
+	require.NoError(t, err)
+	require.Equal(t, "no allergies", updated.Notes)
+	require.Equal(t, []byte{3}, updated.Scan)
+	require.Equal(t, "jd", *updated.Nickname)
+
+	withoutKey, err := Open(opts, &immugorm.ImmuGormConfig{})
+	require.NoError(t, err)
+	err = withoutKey.First(&Patient{}).Error
+	require.ErrorIs(t, err, immugorm.ErrMissingKeyProvider)
+
+	wrongKey, err := Open(opts, &immugorm.ImmuGormConfig{KeyProvider: immugorm.StaticKey(make([]byte, 32))})
+	require.NoError(t, err)
+	err = wrongKey.First(&Patient{}).Error
+	require.ErrorIs(t, err, immugorm.ErrDecryption)
+}
+
+func TestEncryptionOrderAndAggregation(t *testing.T) {
+	opts, close := StartServer()
+	defer close()
+
+	key := immugorm.StaticKey(bytes.Repeat([]byte{0x42}, 32))
+	db, err := Open(opts, &immugorm.ImmuGormConfig{KeyProvider: key})
+	require.NoError(t, err)
+
+	err = db.AutoMigrate(&Patient{})
+	require.NoError(t, err)
+	err = db.Create(&[]Patient{{Name: "John", SSN: "123-45-6789", Notes: "b"}, {Name: "Jane", SSN: "987-65-4321", Notes: "a"}}).Error
+	require.NoError(t, err)
+
+	// the ciphertext is neither ordered nor comparable like the values, on the server or on the client
+	var patients []Patient
+	err = db.Order("notes").Find(&patients).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedOrder)
+	err = db.Order("ssn desc").Find(&patients).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedOrder)
+
+	type NicknameCount struct {
+		Nickname *string
+		Total    int64
+	}
+	var counts []NicknameCount
+	err = db.Model(&Patient{}).Select("nickname, count(*) AS total").Group("nickname").Find(&counts).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedAggregation)
+
+	type NameNotes struct {
+		Name  string
+		Notes string
+	}
+	var notes []NameNotes
+	err = db.Model(&Patient{}).Select("name, max(notes) AS notes").Group("name").Find(&notes).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedAggregation)
+
+	// counting the encrypted values only compares them with NULL
+	type NameCount struct {
+		Name      string
+		Total     int64
+		Nicknames int64
+	}
+	var noted []NameCount
+	err = db.Model(&Patient{}).Select("name, count(notes) AS total, count(nickname) AS nicknames").Group("name").Order("name").Find(&noted).Error
+	require.NoError(t, err)
+	require.Equal(t, []NameCount{{Name: "Jane", Total: 1}, {Name: "John", Total: 1}}, noted)
+
+	err = db.Order("name").Find(&patients).Error
+	require.NoError(t, err)
+	require.Equal(t, "Jane", patients[0].Name)
+	require.Equal(t, "a", patients[0].Notes)
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"testing"
+)
+
+type Measurement struct {
+	ID          uint
+	Temperature float64 `gorm:"index"`
+	Ratio       float32
+	Offset      *float64
+	Price       immugorm.Decimal `gorm:"index"`
+}
+
+func mustParseDecimal(t *testing.T, s string) immugorm.Decimal {
+	d, err := immugorm.ParseDecimal(s)
+	require.NoError(t, err)
+	return d
+}
+
+func TestFloat(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Measurement{})
+	require.NoError(t, err)
+
+	offset := -0.125
+	measurements := []Measurement{
+		{Temperature: 21.5, Ratio: 0.1, Price: mustParseDecimal(t, "9.99")},
+		{Temperature: -3.75, Ratio: -2.5, Offset: &offset, Price: mustParseDecimal(t, "-10.5")},
+		{Temperature: math.Pi, Ratio: math.MaxFloat32, Price: mustParseDecimal(t, "1000000.000001")},
+		{Temperature: -1e300, Ratio: math.SmallestNonzeroFloat32, Price: mustParseDecimal(t, "-10.25")},
+	}
+	for i := range measurements {
+		err = db.Create(&measurements[i]).Error
+		require.NoError(t, err)
+	}
+
+	var stored []Measurement
+	err = db.Find(&stored).Error
+	require.NoError(t, err)
+	require.Equal(t, measurements, stored)
+
+	var temperatures []Measurement
+	err = db.Where("temperature > ?", -3.75).Order("temperature").Find(&temperatures).Error
+	require.NoError(t, err)
+	require.Len(t, temperatures, 2)
+	require.Equal(t, math.Pi, temperatures[0].Temperature)
+	require.Equal(t, 21.5, temperatures[1].Temperature)
+
+	var prices []Measurement
+	err = db.Where("price < ?", mustParseDecimal(t, "9.99")).Order("price").Find(&prices).Error
+	require.NoError(t, err)
+	require.Len(t, prices, 2)
+	require.Equal(t, "-10.5", prices[0].Price.String())
+	require.Equal(t, "-10.25", prices[1].Price.String())
+
+	var first Measurement
+	err = db.Where("price = ?", mustParseDecimal(t, "9.990")).First(&first).Error
+	require.NoError(t, err)
+	require.Equal(t, measurements[0].ID, first.ID)
+
+	err = db.Model(&first).Updates(map[string]interface{}{"temperature": 22.25, "offset": 0.5}).Error
+	require.NoError(t, err)
+
+	var updated Measurement
+	err = db.First(&updated, first.ID).Error
+	require.NoError(t, err)
+	require.Equal(t, 22.25, updated.Temperature)
+	require.Equal(t, 0.5, *updated.Offset)
+}
+
+func TestFloatParameters(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Measurement{})
+	require.NoError(t, err)
+
+	measurements := []Measurement{{Temperature: 21.5, Ratio: 0.5}, {Temperature: -3.75, Ratio: 2}, {Temperature: 10, Ratio: 1}}
+	err = db.Create(&measurements).Error
+	require.NoError(t, err)
+
+	var found []Measurement
+	err = db.Where(clause.Gte{Column: clause.Column{Name: "temperature"}, Value: 10.0}).Order("temperature").Find(&found).Error
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	require.Equal(t, 10.0, found[0].Temperature)
+
+	found = nil
+	err = db.Where("temperature IN ?", []float64{-3.75, 21.5}).Order("temperature").Find(&found).Error
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+
+	found = nil
+	err = db.Where("measurements.ratio >= ? AND ratio <= ?", float32(0.5), float32(1)).Order("temperature").Find(&found).Error
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	require.Equal(t, float32(1), found[0].Ratio)
+
+	err = db.Model(&measurements[0]).Update("temperature", gorm.Expr("?", 22.5)).Error
+	require.NoError(t, err)
+	var updated Measurement
+	err = db.First(&updated, measurements[0].ID).Error
+	require.NoError(t, err)
+	require.Equal(t, 22.5, updated.Temperature)
+
+	// a float compared with an integer column has no stored counterpart
+	err = db.Where("id > ?", 1.5).Find(&found).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedFloatParameter)
+	err = db.Where(clause.Eq{Column: clause.Column{Name: "id"}, Value: 1.0}).Find(&found).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedFloatParameter)
+
+	// arithmetic on the stored integers would be meaningless
+	err = db.Model(&measurements[0]).Update("temperature", gorm.Expr("temperature + ?", 1.0)).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedFloatParameter)
+	err = db.Where("temperature + ? > ?", 1.0, 20.0).Find(&found).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedFloatParameter)
+
+	err = db.First(&updated, measurements[0].ID).Error
+	require.NoError(t, err)
+	require.Equal(t, 22.5, updated.Temperature)
+}
+
+func TestDecimal(t *testing.T) {
+	d := mustParseDecimal(t, "-0012.3400")
+	require.Equal(t, "-12.34", d.String())
+	unscaled, scale := d.Unscaled()
+	require.Equal(t, int64(-123400), unscaled)
+	require.Equal(t, int32(4), scale)
+	require.Equal(t, -12.34, d.Float64())
+
+	require.Equal(t, "0", mustParseDecimal(t, "-0.00").String())
+	require.Equal(t, "0.5", mustParseDecimal(t, ".5").String())
+	require.Equal(t, "1200", immugorm.NewDecimal(12, -2).String())
+
+	values := []string{"-100", "-10.5", "-10.25", "-10", "-0.001", "0", "0.001", "0.01", "9.99", "10", "10.5", "100"}
+	for i := 1; i < len(values); i++ {
+		require.Equal(t, -1, mustParseDecimal(t, values[i-1]).Cmp(mustParseDecimal(t, values[i])), values[i])
+	}
+	require.Equal(t, 0, mustParseDecimal(t, "1.50").Cmp(mustParseDecimal(t, "1.5")))
+
+	for _, invalid := range []string{"", "1.2.3", "abc", "1e5", "-", "99999999999999999999"} {
+		_, err := immugorm.ParseDecimal(invalid)
+		require.ErrorIs(t, err, immugorm.ErrInvalidDecimal, invalid)
+	}
+
+	var scanned immugorm.Decimal
+	v, err := d.Value()
+	require.NoError(t, err)
+	require.NoError(t, scanned.Scan(v))
+	require.Equal(t, 0, d.Cmp(scanned))
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"testing"
+)
+
+type FkCompany struct {
+	ID   uint
+	Name string
+}
+
+type FkEmployee struct {
+	ID        uint
+	Name      string
+	CompanyID uint
+	Company   FkCompany
+}
+
+type FkDepartment struct {
+	ID    uint
+	Name  string
+	Roles []FkRole `gorm:"foreignKey:DepartmentID;constraint:OnDelete:CASCADE"`
+}
+
+type FkRole struct {
+	ID           uint
+	Name         string
+	DepartmentID uint
+}
+
+type FkTeam struct {
+	ID      uint
+	Name    string
+	Players []FkPlayer `gorm:"foreignKey:TeamID;constraint:OnDelete:SET NULL"`
+}
+
+type FkPlayer struct {
+	ID     uint
+	Name   string
+	TeamID *uint
+}
+
+type FkLeague struct {
+	ID      uint
+	Name    string
+	Banners []FkBanner `gorm:"foreignKey:LeagueID;constraint:OnDelete:CASCADE"`
+	Clubs   []FkClub   `gorm:"foreignKey:LeagueID;constraint:OnDelete:CASCADE"`
+}
+
+type FkBanner struct {
+	ID       uint
+	Name     string
+	LeagueID uint
+}
+
+type FkClub struct {
+	ID       uint
+	Name     string
+	LeagueID uint
+	Fans     []FkFan `gorm:"foreignKey:ClubID"`
+}
+
+type FkFan struct {
+	ID     uint
+	Name   string
+	ClubID uint
+}
+
+func TestForeignKeys(t *testing.T) {
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{ForeignKeys: true})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&FkCompany{}, &FkEmployee{}, &FkDepartment{}, &FkRole{}, &FkTeam{}, &FkPlayer{})
+	require.NoError(t, err)
+	// migrating again finds the constraints
+	err = db.AutoMigrate(&FkEmployee{}, &FkRole{}, &FkPlayer{})
+	require.NoError(t, err)
+	require.True(t, db.Migrator().HasConstraint(&FkEmployee{}, "fk_fk_employees_company"))
+
+	// belongs to
+	err = db.Create(&FkEmployee{Name: "orphan", CompanyID: 42}).Error
+	require.ErrorIs(t, err, immugorm.ErrForeignKeyViolation)
+
+	company := &FkCompany{Name: "company"}
+	err = db.Create(company).Error
+	require.NoError(t, err)
+
+	employee := &FkEmployee{Name: "employee", CompanyID: company.ID}
+	err = db.Create(employee).Error
+	require.NoError(t, err)
+
+	err = db.Create(&FkEmployee{Name: "with company", Company: FkCompany{Name: "other company"}}).Error
+	require.NoError(t, err)
+
+	err = db.Model(employee).Update("company_id", 42).Error
+	require.ErrorIs(t, err, immugorm.ErrForeignKeyViolation)
+
+	// RESTRICT is the default action
+	err = db.Delete(company).Error
+	require.ErrorIs(t, err, immugorm.ErrForeignKeyViolation)
+
+	var count int64
+	err = db.Model(&FkCompany{}).Where("id = ?", company.ID).Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	err = db.Delete(&FkEmployee{}, "company_id = ?", company.ID).Error
+	require.NoError(t, err)
+	err = db.Delete(company).Error
+	require.NoError(t, err)
+
+	// references are checked inside the transaction
+	err = db.Transaction(func(tx *gorm.DB) error {
+		company := &FkCompany{Name: "company in tx"}
+		if err := tx.Create(company).Error; err != nil {
+			return err
+		}
+		return tx.Create(&FkEmployee{Name: "employee in tx", CompanyID: company.ID}).Error
+	})
+	require.NoError(t, err)
+
+	// CASCADE
+	department := &FkDepartment{Name: "department", Roles: []FkRole{{Name: "role1"}, {Name: "role2"}}}
+	err = db.Create(department).Error
+	require.NoError(t, err)
+
+	err = db.Delete(department).Error
+	require.NoError(t, err)
+
+	err = db.Model(&FkRole{}).Where("department_id = ?", department.ID).Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(0), count)
+
+	// SET NULL
+	team := &FkTeam{Name: "team", Players: []FkPlayer{{Name: "player1"}, {Name: "player2"}}}
+	err = db.Create(team).Error
+	require.NoError(t, err)
+
+	err = db.Delete(team).Error
+	require.NoError(t, err)
+
+	var players []FkPlayer
+	err = db.Find(&players).Error
+	require.NoError(t, err)
+	require.Len(t, players, 2)
+	for _, player := range players {
+		require.Nil(t, player.TeamID)
+	}
+
+	// enforcement can be suspended, for instance to load data in any order
+	err = db.Migrator().(immugorm.Migrator).RunWithoutForeignKey(func(tx *gorm.DB) error {
+		// the other sessions are still checked
+		require.ErrorIs(t, db.Create(&FkEmployee{Name: "checked", CompanyID: 42}).Error, immugorm.ErrForeignKeyViolation)
+		return tx.Create(&FkEmployee{Name: "loaded", CompanyID: 42}).Error
+	})
+	require.NoError(t, err)
+
+	err = db.Migrator().DropConstraint(&FkEmployee{}, "fk_fk_employees_company")
+	require.NoError(t, err)
+	require.False(t, db.Migrator().HasConstraint(&FkEmployee{}, "fk_fk_employees_company"))
+
+	err = db.Create(&FkEmployee{Name: "unchecked", CompanyID: 43}).Error
+	require.NoError(t, err)
+}
+
+func TestForeignKeysDeleteTransaction(t *testing.T) {
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{ForeignKeys: true})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&FkLeague{}, &FkBanner{}, &FkClub{}, &FkFan{})
+	require.NoError(t, err)
+
+	league := &FkLeague{Name: "league", Banners: []FkBanner{{Name: "banner"}}, Clubs: []FkClub{{Name: "club", Fans: []FkFan{{Name: "fan"}}}}}
+	err = db.Create(league).Error
+	require.NoError(t, err)
+
+	// the banners are deleted before the fans restrict the deletion of the clubs, the delete is rolled back
+	err = db.Delete(league).Error
+	require.ErrorIs(t, err, immugorm.ErrForeignKeyViolation)
+
+	var count int64
+	err = db.Model(&FkBanner{}).Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	err = db.Delete(&FkFan{}, "club_id = ?", league.Clubs[0].ID).Error
+	require.NoError(t, err)
+	res := db.Delete(league)
+	require.NoError(t, res.Error)
+	require.Equal(t, int64(1), res.RowsAffected)
+
+	for _, model := range []interface{}{&FkLeague{}, &FkBanner{}, &FkClub{}} {
+		err = db.Model(model).Count(&count).Error
+		require.NoError(t, err)
+		require.Equal(t, int64(0), count)
+	}
+}
+
+func TestForeignKeysDisabled(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.Migrator().CreateConstraint(&FkEmployee{}, "fk_fk_employees_company")
+	require.ErrorIs(t, err, immugorm.ErrConstraintsNotImplemented)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type GeneratedUser struct {
+	ID        uint
+	Email     string `gorm:"size:64;uniqueIndex;not null"`
+	Nickname  string `gorm:"size:32;index"`
+	Active    bool
+	Avatar    []byte
+	CreatedAt time.Time
+}
+
+type GeneratedTag struct {
+	Code  int    `gorm:"primaryKey;autoIncrement:false"`
+	Label string `gorm:"primaryKey;size:32"`
+}
+
+func TestGenerateModels(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&GeneratedUser{}, &GeneratedTag{})
+	require.NoError(t, err)
+
+	tables, err := db.Migrator().GetTables()
+	require.NoError(t, err)
+	require.Subset(t, tables, []string{"generated_users", "generated_tags"})
+
+	opts := &immugorm.GenerateOptions{Package: "models", Tables: []string{"generated_users", "generated_tags"}}
+	src, err := immugorm.GenerateModels(db, opts)
+	require.NoError(t, err)
+
+	require.Equal(t, "// Code generated by immugorm-gen. DO NOT EDIT.\n"+
+		"\n"+
+		"package models\n"+
+		"\n"+
+		"import \"time\"\n"+
+		"\n"+
+		"type GeneratedTag struct {\n"+
+		"\tCode  int64  `gorm:\"column:code;primaryKey\"`\n"+
+		"\tLabel string `gorm:\"column:label;primaryKey;size:32\"`\n"+
+		"}\n"+
+		"\n"+
+		"func (GeneratedTag) TableName() string {\n"+
+		"\treturn \"generated_tags\"\n"+
+		"}\n"+
+		"\n"+
+		"type GeneratedUser struct {\n"+
+		"\tID        int64      `gorm:\"column:id;primaryKey;autoIncrement\"`\n"+
+		"\tEmail     string     `gorm:\"column:email;size:64;not null;uniqueIndex\"`\n"+
+		"\tNickname  *string    `gorm:\"column:nickname;size:32;index\"`\n"+
+		"\tActive    *bool      `gorm:\"column:active\"`\n"+
+		"\tAvatar    []byte     `gorm:\"column:avatar\"`\n"+
+		"\tCreatedAt *time.Time `gorm:\"column:created_at\"`\n"+
+		"}\n"+
+		"\n"+
+		"func (GeneratedUser) TableName() string {\n"+
+		"\treturn \"generated_users\"\n"+
+		"}\n", string(src))
+
+	again, err := immugorm.GenerateModels(db, opts)
+	require.NoError(t, err)
+	require.Equal(t, src, again)
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type OrderLine struct {
+	ID       uint
+	Status   string `gorm:"size:32;index"`
+	Customer string `gorm:"size:32"`
+	Quantity int64
+	Price    float64
+}
+
+type StatusCount struct {
+	Status string
+	Total  int64
+}
+
+type CustomerTotals struct {
+	Customer string
+	Lines    int64
+	Quantity int64
+	MinPrice float64
+	MaxPrice float64
+	AvgPrice float64
+}
+
+func TestGroupBy(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&OrderLine{})
+	require.NoError(t, err)
+
+	lines := []OrderLine{
+		{Status: "shipped", Customer: "alice", Quantity: 1, Price: 1.5},
+		{Status: "pending", Customer: "bob", Quantity: 2, Price: 2.5},
+		{Status: "shipped", Customer: "bob", Quantity: 3, Price: 3.5},
+		{Status: "shipped", Customer: "carol", Quantity: 4, Price: 4.5},
+		{Status: "cancelled", Customer: "alice", Quantity: 5, Price: 5.5},
+	}
+	err = db.Create(&lines).Error
+	require.NoError(t, err)
+
+	// grouped by an indexed column, immudb groups the rows
+	var counts []StatusCount
+	err = db.Model(&OrderLine{}).Group("status").Select("status, count(*) as total").Find(&counts).Error
+	require.NoError(t, err)
+	require.Equal(t, []StatusCount{{"cancelled", 1}, {"pending", 1}, {"shipped", 3}}, counts)
+
+	counts = nil
+	err = db.Model(&OrderLine{}).Group("status").Select("status, count(*) as total").
+		Having("count(*) > ?", 1).Scan(&counts).Error
+	require.NoError(t, err)
+	require.Equal(t, []StatusCount{{"shipped", 3}}, counts)
+
+	var count int64
+	err = db.Model(&OrderLine{}).Group("status").Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(3), count)
+
+	// grouped by a column without index, the rows are aggregated on the client
+	var totals []CustomerTotals
+	err = db.Model(&OrderLine{}).Group("customer").
+		Select("customer, count(*) as lines, sum(quantity) as quantity, min(price) as min_price, max(price) as max_price, avg(price) as avg_price").
+		Find(&totals).Error
+	require.NoError(t, err)
+	require.Equal(t, []CustomerTotals{
+		{Customer: "alice", Lines: 2, Quantity: 6, MinPrice: 1.5, MaxPrice: 5.5, AvgPrice: 3.5},
+		{Customer: "bob", Lines: 2, Quantity: 5, MinPrice: 2.5, MaxPrice: 3.5, AvgPrice: 3},
+		{Customer: "carol", Lines: 1, Quantity: 4, MinPrice: 4.5, MaxPrice: 4.5, AvgPrice: 4.5},
+	}, totals)
+
+	totals = nil
+	err = db.Model(&OrderLine{}).Where("status != ?", "cancelled").Group("customer").
+		Select("customer, sum(quantity) as quantity").
+		Having("sum(quantity) >= ? OR customer = 'carol'", 5).Order("quantity desc").Limit(2).
+		Find(&totals).Error
+	require.NoError(t, err)
+	require.Equal(t, []CustomerTotals{{Customer: "bob", Quantity: 5}, {Customer: "carol", Quantity: 4}}, totals)
+
+	count = 0
+	err = db.Model(&OrderLine{}).Group("customer").Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(3), count)
+
+	rows, err := db.Model(&OrderLine{}).Group("status, customer").Select("status, customer, count(*)").Rows()
+	require.NoError(t, err)
+	var groups int
+	for rows.Next() {
+		groups++
+	}
+	require.NoError(t, rows.Close())
+	require.Equal(t, 5, groups)
+
+	// the columns neither grouped nor aggregated cannot be selected
+	err = db.Model(&OrderLine{}).Group("customer").Select("customer, quantity").Find(&totals).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedAggregation)
+}
+
+func TestGroupByVerify(t *testing.T) {
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{Verify: true})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&OrderLine{})
+	require.NoError(t, err)
+	err = db.Create(&[]OrderLine{{Status: "shipped", Customer: "alice"}, {Status: "pending", Customer: "bob"}}).Error
+	require.NoError(t, err)
+
+	var line OrderLine
+	err = db.Where("status = ?", "pending").First(&line).Error
+	require.NoError(t, err)
+
+	// grouped on the server or on the client, the groups have no proof
+	var counts []StatusCount
+	err = db.Model(&OrderLine{}).Select("status, count(*) AS total").Group("status").Find(&counts).Error
+	require.ErrorIs(t, err, immugorm.ErrGroupByNotVerifiable)
+	var totals []CustomerTotals
+	err = db.Model(&OrderLine{}).Select("customer, count(*) AS lines").Group("customer").Find(&totals).Error
+	require.ErrorIs(t, err, immugorm.ErrGroupByNotVerifiable)
+}
@@ -18,24 +18,24 @@ package tests
 
 import (
 	"database/sql"
+	immugorm "github.com/codenotary/immugorm"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
 
 func TestIndexes(t *testing.T) {
 	type Profile struct {
-		ID      uint
-		Refer   uint   `gorm:"uniqueIndex"`
-		Name    string `gorm:"index;size:252"`
-		Content []byte `gorm:"index;size:252"`
-		Age     sql.NullInt64
-		Email   string `gorm:"size:252;unique_index"`
-		Role    string `gorm:"size:252"` // set field size to 252
-		//MemberNumber *string `gorm:"unique;not null;size:252"` // set member number to unique and not null not yet supported
-		//MemberNumber *string `gorm:"not null;size:252"` // set member number not null
-		Num      int    `gorm:"AUTO_INCREMENT"`      // set num to auto incrementable
-		Address  string `gorm:"index:addr;size:252"` // create index with name `addr` for address
-		IgnoreMe int    `gorm:"-"`                   // ignore this field
+		ID           uint
+		Refer        uint   `gorm:"uniqueIndex"`
+		Name         string `gorm:"index;size:252"`
+		Content      []byte `gorm:"index;size:252"`
+		Age          sql.NullInt64
+		Email        string  `gorm:"size:252;unique_index"`
+		Role         string  `gorm:"size:252"`                 // set field size to 252
+		MemberNumber *string `gorm:"unique;not null;size:252"` // set member number to unique and not null
+		Num          int     `gorm:"AUTO_INCREMENT"`           // set num to auto incrementable
+		Address      string  `gorm:"index:addr;size:252"`      // create index with name `addr` for address
+		IgnoreMe     int     `gorm:"-"`                        // ignore this field
 	}
 
 	DB, close, err := OpenDB()
@@ -46,20 +46,52 @@ func TestIndexes(t *testing.T) {
 		t.Fatalf("Failed to migrate, got error: %v", err)
 	}
 
-	//myNumber := "myNumber"
+	myNumber := "myNumber"
 
 	err = DB.Create(&Profile{
-		Refer:   2,
-		Name:    "name",
-		Content: []byte(`content`),
-		Age:     sql.NullInt64{},
-		Email:   "my@email.it",
-		Role:    "my_role",
-		//MemberNumber: &myNumber,
-		Address:  "my_address",
-		IgnoreMe: 55,
+		Refer:        2,
+		Name:         "name",
+		Content:      []byte(`content`),
+		Age:          sql.NullInt64{},
+		Email:        "my@email.it",
+		Role:         "my_role",
+		MemberNumber: &myNumber,
+		Address:      "my_address",
+		IgnoreMe:     55,
 	}).Error
 	require.NoError(t, err)
+
+	// member number is not null
+	err = DB.Create(&Profile{Refer: 3, Name: "name"}).Error
+	require.Error(t, err)
+
+	// member number is unique
+	err = DB.Create(&Profile{Refer: 4, Name: "name", MemberNumber: &myNumber}).Error
+	require.Error(t, err)
+
+	otherNumber := "otherNumber"
+	err = DB.Create(&Profile{Refer: 5, Name: "name", MemberNumber: &otherNumber}).Error
+	require.NoError(t, err)
+}
+
+func TestIndexedColumnSize(t *testing.T) {
+	type Account struct {
+		ID    uint
+		Email string `gorm:"uniqueIndex"`
+	}
+	type Session struct {
+		Token string `gorm:"primaryKey;size:512"`
+	}
+
+	DB, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = DB.AutoMigrate(&Account{})
+	require.ErrorIs(t, err, immugorm.ErrInvalidIndexedColumnSize)
+
+	err = DB.AutoMigrate(&Session{})
+	require.ErrorIs(t, err, immugorm.ErrInvalidIndexedColumnSize)
 }
 
 func TestCompositeIndex(t *testing.T) {
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+type IntegerWidths struct {
+	ID      uint `gorm:"primarykey"`
+	I8      int8
+	I16     int16
+	I32     int32
+	I64     int64
+	U8      uint8
+	U16     uint16
+	U32     uint32
+	U64     uint64
+	U       uint
+	Counter immugorm.Uint64 `gorm:"index"`
+}
+
+func TestIntegerWidths(t *testing.T) {
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{Verify: true})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&IntegerWidths{}, &Entity{})
+	require.NoError(t, err)
+
+	entity := Entity{I32: math.MinInt32, Ui32: math.MaxUint32}
+	err = db.Create(&entity).Error
+	require.NoError(t, err)
+
+	var storedEntity Entity
+	err = db.First(&storedEntity, entity.ID).Error
+	require.NoError(t, err)
+	require.Equal(t, int32(math.MinInt32), storedEntity.I32)
+	require.Equal(t, uint32(math.MaxUint32), storedEntity.Ui32)
+
+	widths := []IntegerWidths{
+		{
+			I8: math.MinInt8, I16: math.MinInt16, I32: math.MinInt32, I64: math.MinInt64,
+			Counter: 0,
+		},
+		{
+			I8: math.MaxInt8, I16: math.MaxInt16, I32: math.MaxInt32, I64: math.MaxInt64,
+			U8: math.MaxUint8, U16: math.MaxUint16, U32: math.MaxUint32, U64: math.MaxInt64, U: math.MaxInt64,
+			Counter: math.MaxUint64,
+		},
+		{
+			U64: 1 << 40, U: 1,
+			Counter: math.MaxInt64 + 1,
+		},
+	}
+	err = db.Create(&widths).Error
+	require.NoError(t, err)
+
+	var stored []IntegerWidths
+	err = db.Find(&stored).Error
+	require.NoError(t, err)
+	require.Equal(t, widths, stored)
+
+	var counters []IntegerWidths
+	err = db.Where("counter > ?", immugorm.Uint64(math.MaxInt64)).Order("counter").Find(&counters).Error
+	require.NoError(t, err)
+	require.Len(t, counters, 2)
+	require.Equal(t, immugorm.Uint64(math.MaxInt64+1), counters[0].Counter)
+	require.Equal(t, immugorm.Uint64(math.MaxUint64), counters[1].Counter)
+
+	err = db.Create(&IntegerWidths{U64: math.MaxInt64 + 1}).Error
+	require.ErrorIs(t, err, immugorm.ErrIntegerOverflow)
+
+	err = db.Model(&stored[0]).Update("u", uint(math.MaxUint64)).Error
+	require.ErrorIs(t, err, immugorm.ErrIntegerOverflow)
+
+	err = db.Where("u64 = ?", uint64(math.MaxUint64)).Find(&counters).Error
+	require.ErrorIs(t, err, immugorm.ErrIntegerOverflow)
+
+	err = db.Find(&stored).Error
+	require.NoError(t, err)
+	require.Equal(t, widths, stored)
+}
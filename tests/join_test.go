@@ -0,0 +1,113 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type Publisher struct {
+	ID   uint
+	Name string `gorm:"size:64;index"`
+}
+
+type Book struct {
+	ID          uint
+	Title       string
+	PublisherID *uint
+	Publisher   *Publisher
+	Cover       *Cover
+}
+
+type Cover struct {
+	ID     uint
+	BookID uint
+	Color  string
+}
+
+func TestOuterJoins(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Publisher{}, &Book{}, &Cover{})
+	require.NoError(t, err)
+
+	publisher := Publisher{Name: "Penguin"}
+	err = db.Create(&publisher).Error
+	require.NoError(t, err)
+	books := []Book{
+		{Title: "Dracula", PublisherID: &publisher.ID},
+		{Title: "Unpublished"},
+	}
+	err = db.Create(&books).Error
+	require.NoError(t, err)
+	err = db.Create(&Cover{BookID: books[1].ID, Color: "red"}).Error
+	require.NoError(t, err)
+
+	// rows without a related record are kept
+	var stored []Book
+	err = db.Joins("Publisher").Joins("Cover").Order("id").Find(&stored).Error
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	require.Equal(t, publisher, *stored[0].Publisher)
+	require.Nil(t, stored[0].Cover)
+	require.Nil(t, stored[1].Publisher)
+	require.Equal(t, "red", stored[1].Cover.Color)
+
+	// the conditions of the join apply to the related record only
+	stored = nil
+	err = db.Joins("Publisher", db.Where(&Publisher{Name: "Other"})).Order("id").Find(&stored).Error
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	require.Nil(t, stored[0].Publisher)
+
+	// conditions on the related records filter the rows
+	stored = nil
+	err = db.Joins("Publisher").Where("Publisher.name = ?", "Penguin").Find(&stored).Error
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	require.Equal(t, "Dracula", stored[0].Title)
+	require.Equal(t, publisher, *stored[0].Publisher)
+
+	// conditions keeping the rows without a related record cannot be sent with an inner join
+	err = db.Joins("Publisher").Where("Publisher.name IS NULL").Find(&stored).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedJoin)
+	err = db.Joins("Publisher").Where("Publisher.name = ?", "Penguin").Or("title = ?", "Unpublished").Find(&stored).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedJoin)
+	err = db.Joins("Publisher").Order("Publisher.name").Find(&stored).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedJoin)
+
+	var book Book
+	err = db.Joins("Publisher").First(&book, books[1].ID).Error
+	require.NoError(t, err)
+	require.Equal(t, "Unpublished", book.Title)
+	require.Nil(t, book.Publisher)
+
+	var count int64
+	err = db.Model(&Book{}).Joins("Publisher").Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(2), count)
+
+	stored = nil
+	err = db.Preload("Publisher").Order("id").Find(&stored).Error
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	require.Nil(t, stored[1].Publisher)
+}
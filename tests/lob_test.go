@@ -0,0 +1,111 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"testing"
+)
+
+type Document struct {
+	ID         uint
+	Name       string `gorm:"size:64"`
+	Content    []byte `gorm:"type:immudb_lob"`
+	Summary    string `gorm:"type:immudb_lob"`
+	Attachment immugorm.LargeObject
+}
+
+func TestLargeObjects(t *testing.T) {
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{Verify: true})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Document{})
+	require.NoError(t, err)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), immugorm.LobChunkSize*5/32)
+	attachment := bytes.Repeat([]byte{0xca, 0xfe}, immugorm.LobChunkSize)
+	document := Document{
+		Name:       "contract",
+		Content:    content,
+		Summary:    "a short summary",
+		Attachment: immugorm.NewLargeObject(attachment),
+	}
+	err = db.Create(&document).Error
+	require.NoError(t, err)
+
+	var stored Document
+	err = db.First(&stored, document.ID).Error
+	require.NoError(t, err)
+	require.Equal(t, content, stored.Content)
+	require.Equal(t, "a short summary", stored.Summary)
+
+	sum := sha256.Sum256(attachment)
+	require.Equal(t, hex.EncodeToString(sum[:]), stored.Attachment.Hash())
+	require.Equal(t, int64(len(attachment)), stored.Attachment.Size())
+	loaded, err := stored.Attachment.Load(db)
+	require.NoError(t, err)
+	require.Equal(t, attachment, loaded)
+
+	var reference string
+	err = db.Table("documents").Select("content").Where("id = ?", document.ID).Row().Scan(&reference)
+	require.NoError(t, err)
+	sum = sha256.Sum256(content)
+	require.Equal(t, hex.EncodeToString(sum[:])+":2621440", reference)
+
+	// the content is already stored, only the row is written
+	copied := Document{Name: "copy", Content: content}
+	err = db.Create(&copied).Error
+	require.NoError(t, err)
+
+	var storedCopy Document
+	err = db.First(&storedCopy, copied.ID).Error
+	require.NoError(t, err)
+	require.Equal(t, content, storedCopy.Content)
+	require.Empty(t, storedCopy.Attachment.Hash())
+	loaded, err = storedCopy.Attachment.Load(db)
+	require.NoError(t, err)
+	require.Nil(t, loaded)
+
+	updated := []byte("updated content")
+	err = db.Model(&stored).Updates(map[string]interface{}{"content": updated}).Error
+	require.NoError(t, err)
+	require.Equal(t, updated, stored.Content)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(&Document{Name: "tx", Summary: "written in a transaction"}).Error
+	})
+	require.ErrorIs(t, err, immugorm.ErrLobInTransaction)
+
+	// values already stored can be referenced inside transactions
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return tx.Model(&copied).Update("summary", "a short summary").Error
+	})
+	require.NoError(t, err)
+
+	var documents []Document
+	err = db.Order("id").Find(&documents).Error
+	require.NoError(t, err)
+	require.Len(t, documents, 2)
+	require.Equal(t, updated, documents[0].Content)
+	require.Equal(t, "a short summary", documents[1].Summary)
+}
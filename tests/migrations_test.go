@@ -0,0 +1,160 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"errors"
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestMigrationRunner(t *testing.T) {
+	type Customer struct {
+		ID   uint
+		Name string
+	}
+	type Invoice struct {
+		ID     uint
+		Amount uint
+	}
+
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	migrations := []*immugorm.Migration{
+		{ID: "0001_customers", Migrate: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&Customer{})
+		}},
+		{ID: "0002_invoices", Migrate: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&Invoice{})
+		}},
+	}
+
+	ids, err := immugorm.NewMigrationRunner(db, migrations[:1], &immugorm.MigrationOptions{DryRun: true}).Migrate()
+	require.NoError(t, err)
+	require.Equal(t, []string{"0001_customers"}, ids)
+	require.False(t, db.Migrator().HasTable(&Customer{}))
+
+	ids, err = immugorm.NewMigrationRunner(db, migrations[:1], nil).Migrate()
+	require.NoError(t, err)
+	require.Equal(t, []string{"0001_customers"}, ids)
+	require.True(t, db.Migrator().HasTable(&Customer{}))
+
+	runner := immugorm.NewMigrationRunner(db, migrations, nil)
+	ids, err = runner.Migrate()
+	require.NoError(t, err)
+	require.Equal(t, []string{"0002_invoices"}, ids)
+
+	ids, err = runner.Migrate()
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	applied, err := runner.Applied()
+	require.NoError(t, err)
+	require.Len(t, applied, 2)
+	require.Equal(t, "0001_customers", applied[0].ID)
+	require.Equal(t, "0002_invoices", applied[1].ID)
+	require.True(t, applied[0].TxID > 0)
+	require.True(t, applied[1].TxID > applied[0].TxID)
+
+	// the record is committed with the schema changes
+	var count int64
+	err = db.Model(&immugorm.SchemaMigration{}).Clauses(immugorm.BeforeTx(applied[1].TxID)).Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+	err = db.Model(&immugorm.SchemaMigration{}).Clauses(immugorm.BeforeTx(applied[1].TxID + 1)).Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(2), count)
+
+	_, err = immugorm.NewMigrationRunner(db, []*immugorm.Migration{migrations[1], migrations[0]}, nil).Migrate()
+	require.ErrorIs(t, err, immugorm.ErrMigrationHistoryMismatch)
+
+	_, err = immugorm.NewMigrationRunner(db, migrations[:1], nil).Migrate()
+	require.ErrorIs(t, err, immugorm.ErrMigrationHistoryMismatch)
+}
+
+func TestMigrationRunnerAtomicity(t *testing.T) {
+	type Supplier struct {
+		ID   uint
+		Name string
+	}
+	type Shipment struct {
+		ID     uint
+		Weight uint
+	}
+
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	failure := errors.New("failure")
+	migrations := []*immugorm.Migration{
+		{ID: "0001_suppliers", Migrate: func(tx *gorm.DB) error {
+			if err := tx.Migrator().CreateTable(&Supplier{}); err != nil {
+				return err
+			}
+			return failure
+		}},
+	}
+
+	_, err = immugorm.NewMigrationRunner(db, migrations, nil).Migrate()
+	require.ErrorIs(t, err, failure)
+	require.False(t, db.Migrator().HasTable(&Supplier{}))
+
+	migrations[0].Migrate = func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&Supplier{})
+	}
+	ids, err := immugorm.NewMigrationRunner(db, migrations, nil).Migrate()
+	require.NoError(t, err)
+	require.Equal(t, []string{"0001_suppliers"}, ids)
+	require.True(t, db.Migrator().HasTable(&Supplier{}))
+
+	migrations = append(migrations, &immugorm.Migration{ID: "0002_shipments", NoTransaction: true, Migrate: func(tx *gorm.DB) error {
+		if err := tx.Migrator().CreateTable(&Shipment{}); err != nil {
+			return err
+		}
+		return failure
+	}})
+	runner := immugorm.NewMigrationRunner(db, migrations, nil)
+	_, err = runner.Migrate()
+	require.ErrorIs(t, err, failure)
+	require.True(t, db.Migrator().HasTable(&Shipment{}))
+
+	applied, err := runner.Applied()
+	require.NoError(t, err)
+	require.Len(t, applied, 2)
+	require.False(t, applied[0].IsPending())
+	require.True(t, applied[1].IsPending())
+
+	_, err = runner.Migrate()
+	require.ErrorIs(t, err, immugorm.ErrMigrationInterrupted)
+
+	require.ErrorIs(t, runner.Resolve("0001_suppliers", true), immugorm.ErrInvalidMigration)
+	require.NoError(t, runner.Resolve("0002_shipments", true))
+
+	ids, err = runner.Migrate()
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	applied, err = runner.Applied()
+	require.NoError(t, err)
+	require.False(t, applied[1].IsPending())
+	require.True(t, applied[1].TxID > applied[0].TxID)
+}
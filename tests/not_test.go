@@ -0,0 +1,112 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"testing"
+)
+
+type Member struct {
+	ID       uint
+	Name     string `gorm:"size:32;index"`
+	Age      int
+	Active   bool
+	Nickname *string `gorm:"size:16"`
+}
+
+func TestNotConditions(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Member{})
+	require.NoError(t, err)
+
+	nickname := "bob"
+	members := []Member{
+		{Name: "alice", Age: 30, Active: true},
+		{Name: "bob", Age: 40, Active: false, Nickname: &nickname},
+		{Name: "carol", Age: 50, Active: true},
+	}
+	err = db.Create(&members).Error
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		query    func(tx *gorm.DB) *gorm.DB
+		expected []string
+	}{
+		{"not string", func(tx *gorm.DB) *gorm.DB { return tx.Not("name = ?", "alice") }, []string{"bob", "carol"}},
+		{"not string with and", func(tx *gorm.DB) *gorm.DB { return tx.Not("name = ? AND age = ?", "alice", 30) }, []string{"bob", "carol"}},
+		{"not struct", func(tx *gorm.DB) *gorm.DB { return tx.Not(Member{Name: "bob"}) }, []string{"alice", "carol"}},
+		{"not map", func(tx *gorm.DB) *gorm.DB { return tx.Not(map[string]interface{}{"name": "bob", "age": 30}) }, []string{"carol"}},
+		{"not map in", func(tx *gorm.DB) *gorm.DB { return tx.Not(map[string]interface{}{"name": []string{"alice", "bob"}}) }, []string{"carol"}},
+		{"not primary keys", func(tx *gorm.DB) *gorm.DB { return tx.Not([]uint{members[0].ID, members[2].ID}) }, []string{"bob"}},
+		{"not null", func(tx *gorm.DB) *gorm.DB { return tx.Not(map[string]interface{}{"nickname": nil}) }, []string{"bob"}},
+		{"not in", func(tx *gorm.DB) *gorm.DB { return tx.Where("name NOT IN ?", []string{"alice"}) }, []string{"bob", "carol"}},
+		{"is not null", func(tx *gorm.DB) *gorm.DB { return tx.Where("nickname IS NOT NULL") }, []string{"bob"}},
+		{"not equal", func(tx *gorm.DB) *gorm.DB { return tx.Where("name <> ?", "bob") }, []string{"alice", "carol"}},
+		{"not equal quoted", func(tx *gorm.DB) *gorm.DB { return tx.Where("name <> '<>'") }, []string{"alice", "bob", "carol"}},
+		{"not and or", func(tx *gorm.DB) *gorm.DB { return tx.Not("age > ?", 45).Or("active = ?", false) }, []string{"alice", "bob"}},
+		{"not group", func(tx *gorm.DB) *gorm.DB { return tx.Not(db.Where("name = ?", "alice").Or("name = ?", "bob")) }, []string{"carol"}},
+		{"where not group", func(tx *gorm.DB) *gorm.DB { return tx.Where("age > ?", 35).Where(db.Not("name = ?", "carol")) }, []string{"bob"}},
+		{"clause not", func(tx *gorm.DB) *gorm.DB {
+			return tx.Clauses(clause.Where{Exprs: []clause.Expression{clause.Not(clause.Eq{Column: "name", Value: "alice"}, clause.Gt{Column: "age", Value: 45})}})
+		}, []string{"bob"}},
+		{"clause not in", func(tx *gorm.DB) *gorm.DB {
+			return tx.Clauses(clause.Not(clause.IN{Column: "name", Values: []interface{}{"carol"}}))
+		}, []string{"alice", "bob"}},
+		{"clause neq", func(tx *gorm.DB) *gorm.DB { return tx.Clauses(clause.Neq{Column: "name", Value: "carol"}) }, []string{"alice", "bob"}},
+		{"clause not or", func(tx *gorm.DB) *gorm.DB {
+			return tx.Clauses(clause.Not(clause.Or(clause.Eq{Column: "name", Value: "alice"}, clause.Eq{Column: "active", Value: false})))
+		}, []string{"carol"}},
+		{"clause not like", func(tx *gorm.DB) *gorm.DB { return tx.Clauses(clause.Not(clause.Like{Column: "name", Value: "^a"})) }, []string{"bob", "carol"}},
+		{"clause double not", func(tx *gorm.DB) *gorm.DB {
+			return tx.Clauses(clause.Not(clause.Not(clause.Eq{Column: "name", Value: "bob"}, clause.Eq{Column: "age", Value: 40})))
+		}, []string{"bob"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var found []Member
+			err := tc.query(db.Model(&Member{})).Find(&found).Error
+			require.NoError(t, err)
+			names := make([]string, len(found))
+			for i, member := range found {
+				names[i] = member.Name
+			}
+			sort.Strings(names)
+			require.Equal(t, tc.expected, names)
+		})
+	}
+
+	// the conditions of updates and deletes are rewritten too
+	err = db.Model(&Member{}).Not("name = ?", "alice").Update("age", 60).Error
+	require.NoError(t, err)
+	var count int64
+	err = db.Model(&Member{}).Where("age = ?", 60).Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(2), count)
+
+	err = db.Not(map[string]interface{}{"nickname": nil}).Delete(&Member{}).Error
+	require.NoError(t, err)
+	err = db.Model(&Member{}).Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(2), count)
+}
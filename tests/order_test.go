@@ -0,0 +1,132 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"fmt"
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"testing"
+)
+
+type Contact struct {
+	ID        uint
+	LastName  string `gorm:"size:32;index:idx_contact_name,priority:1"`
+	FirstName string `gorm:"size:32;index:idx_contact_name,priority:2"`
+	City      string `gorm:"size:32;index"`
+	Age       int
+	Score     float64
+}
+
+func contactNames(contacts []Contact) []string {
+	names := make([]string, len(contacts))
+	for i, contact := range contacts {
+		names[i] = contact.FirstName + " " + contact.LastName
+	}
+	return names
+}
+
+func TestOrder(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Contact{})
+	require.NoError(t, err)
+
+	contacts := []Contact{
+		{LastName: "Smith", FirstName: "John", City: "Rome", Age: 40, Score: 2.5},
+		{LastName: "Doe", FirstName: "Jane", City: "Paris", Age: 30, Score: -1},
+		{LastName: "Smith", FirstName: "Anna", City: "Paris", Age: 25, Score: 7},
+		{LastName: "Doe", FirstName: "Adam", City: "Rome", Age: 30, Score: 0.5},
+	}
+	err = db.Create(&contacts).Error
+	require.NoError(t, err)
+
+	// the composite index orders the rows
+	var stored []Contact
+	tx := db.Session(&gorm.Session{DryRun: true}).Order("last_name, first_name").Find(&stored)
+	require.NoError(t, tx.Error)
+	require.Contains(t, tx.Statement.SQL.String(), "USE INDEX ON (last_name, first_name)")
+	require.Contains(t, tx.Statement.SQL.String(), "ORDER BY last_name")
+	require.NotContains(t, tx.Statement.SQL.String(), "first_name ORDER")
+
+	err = db.Order("last_name, first_name").Find(&stored).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"Adam Doe", "Jane Doe", "Anna Smith", "John Smith"}, contactNames(stored))
+
+	stored = nil
+	err = db.Order("last_name desc").Order("first_name desc").Find(&stored).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"John Smith", "Anna Smith", "Jane Doe", "Adam Doe"}, contactNames(stored))
+
+	// the primary key follows the columns of the index
+	stored = nil
+	err = db.Order("city, id").Find(&stored).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"Jane Doe", "Anna Smith", "John Smith", "Adam Doe"}, contactNames(stored))
+
+	// no index orders the rows, they are sorted on the client
+	stored = nil
+	err = db.Order("age desc, first_name").Find(&stored).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"John Smith", "Adam Doe", "Jane Doe", "Anna Smith"}, contactNames(stored))
+
+	stored = nil
+	err = db.Where("city = ?", "Paris").Order("score").Find(&stored).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"Jane Doe", "Anna Smith"}, contactNames(stored))
+
+	stored = nil
+	err = db.Order("last_name, age").Offset(1).Limit(2).Find(&stored).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"Adam Doe", "Anna Smith"}, contactNames(stored))
+
+	var names []string
+	err = db.Model(&Contact{}).Order("score desc").Pluck("first_name", &names).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"Anna", "John", "Adam", "Jane"}, names)
+
+	var contact Contact
+	err = db.Order("age").First(&contact).Error
+	require.NoError(t, err)
+	require.Equal(t, "Anna", contact.FirstName)
+}
+
+func TestSortLimit(t *testing.T) {
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{SortLimit: 3, Verify: true})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Contact{})
+	require.NoError(t, err)
+
+	for age := 1; age <= 5; age++ {
+		err = db.Create(&Contact{LastName: "Smith", FirstName: fmt.Sprintf("name-%d", age), Age: 6 - age}).Error
+		require.NoError(t, err)
+	}
+
+	// only the rows within the limit are kept
+	var stored []Contact
+	err = db.Order("age").Limit(2).Find(&stored).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"name-5 Smith", "name-4 Smith"}, contactNames(stored))
+
+	err = db.Order("age").Find(&stored).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedOrder)
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"fmt"
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type Ticket struct {
+	ID       uint
+	Priority int    `gorm:"index"`
+	Title    string `gorm:"size:32"`
+}
+
+func ticketTitles(tickets []Ticket) []string {
+	titles := make([]string, len(tickets))
+	for i, ticket := range tickets {
+		titles[i] = ticket.Title
+	}
+	return titles
+}
+
+func TestPagination(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Ticket{})
+	require.NoError(t, err)
+
+	for i := 1; i <= 7; i++ {
+		err = db.Create(&Ticket{Priority: i % 3, Title: fmt.Sprintf("ticket-%d", i)}).Error
+		require.NoError(t, err)
+	}
+
+	// the rows of the offset are skipped
+	var tickets []Ticket
+	err = db.Order("id").Offset(2).Limit(3).Find(&tickets).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"ticket-3", "ticket-4", "ticket-5"}, ticketTitles(tickets))
+
+	tickets = nil
+	err = db.Offset(5).Find(&tickets).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"ticket-6", "ticket-7"}, ticketTitles(tickets))
+
+	err = db.Offset(10).Limit(2).Find(&tickets).Error
+	require.NoError(t, err)
+	require.Empty(t, tickets)
+
+	// pages by primary key
+	var (
+		pages [][]string
+		token string
+	)
+	for {
+		var page []Ticket
+		tx := immugorm.Paginate(db, token, 3).Find(&page)
+		require.NoError(t, tx.Error)
+		pages = append(pages, ticketTitles(page))
+		token, err = immugorm.NextCursor(tx)
+		require.NoError(t, err)
+		if token == "" {
+			break
+		}
+	}
+	require.Equal(t, [][]string{
+		{"ticket-1", "ticket-2", "ticket-3"},
+		{"ticket-4", "ticket-5", "ticket-6"},
+		{"ticket-7"},
+	}, pages)
+
+	// pages by an indexed column, the primary key orders the records of equal priority
+	pages, token = nil, ""
+	for {
+		var page []Ticket
+		tx := immugorm.Paginate(db.Order("priority desc"), token, 2).Find(&page)
+		require.NoError(t, tx.Error)
+		pages = append(pages, ticketTitles(page))
+		token, err = immugorm.NextCursor(tx)
+		require.NoError(t, err)
+		if token == "" {
+			break
+		}
+	}
+	require.Equal(t, [][]string{
+		{"ticket-5", "ticket-2"},
+		{"ticket-7", "ticket-4"},
+		{"ticket-1", "ticket-6"},
+		{"ticket-3"},
+	}, pages)
+
+	// pinned pages are not changed by the later transactions
+	token, err = immugorm.PinCursor(db)
+	require.NoError(t, err)
+	var page []Ticket
+	tx := immugorm.Paginate(db, token, 4).Find(&page)
+	require.NoError(t, tx.Error)
+	require.Equal(t, []string{"ticket-1", "ticket-2", "ticket-3", "ticket-4"}, ticketTitles(page))
+	token, err = immugorm.NextCursor(tx)
+	require.NoError(t, err)
+
+	err = db.Create(&Ticket{Priority: 1, Title: "ticket-8"}).Error
+	require.NoError(t, err)
+	err = db.Model(&Ticket{}).Where("title = ?", "ticket-6").Update("title", "changed").Error
+	require.NoError(t, err)
+
+	page = nil
+	tx = immugorm.Paginate(db, token, 4).Find(&page)
+	require.NoError(t, tx.Error)
+	require.Equal(t, []string{"ticket-5", "ticket-6", "ticket-7"}, ticketTitles(page))
+
+	err = immugorm.Paginate(db.Order("title"), token, 4).Find(&page).Error
+	require.ErrorIs(t, err, immugorm.ErrInvalidCursor)
+	err = immugorm.Paginate(db, "garbage", 4).Find(&page).Error
+	require.ErrorIs(t, err, immugorm.ErrInvalidCursor)
+}
+
+type Quote struct {
+	ID     uint
+	Amount immugorm.Decimal `gorm:"index"`
+	Expiry *int             `gorm:"index"`
+}
+
+func TestPaginationSortKeyValues(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Quote{})
+	require.NoError(t, err)
+
+	expiry := 30
+	for _, amount := range []int64{1250, -75, 990, 100000, 5} {
+		err = db.Create(&Quote{Amount: immugorm.NewDecimal(amount, 2), Expiry: &expiry}).Error
+		require.NoError(t, err)
+	}
+	err = db.Create(&Quote{Amount: immugorm.NewDecimal(1, 0)}).Error
+	require.NoError(t, err)
+
+	// decimals are kept in the token as they are stored
+	var (
+		amounts []string
+		token   string
+	)
+	for {
+		var page []Quote
+		tx := immugorm.Paginate(db.Order("amount"), token, 2).Find(&page)
+		require.NoError(t, tx.Error)
+		for _, quote := range page {
+			amounts = append(amounts, quote.Amount.String())
+		}
+		token, err = immugorm.NextCursor(tx)
+		require.NoError(t, err)
+		if token == "" {
+			break
+		}
+	}
+	require.Equal(t, []string{"-0.75", "0.05", "1", "9.9", "12.5", "1000"}, amounts)
+
+	// the pages following a NULL value cannot be selected
+	var page []Quote
+	tx := immugorm.Paginate(db.Order("expiry"), "", 1).Find(&page)
+	require.NoError(t, tx.Error)
+	require.Nil(t, page[0].Expiry)
+	_, err = immugorm.NextCursor(tx)
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedOrder)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type PlannedItem struct {
+	ID   uint
+	Name string `gorm:"size:32"`
+	Note string
+}
+
+type PlannedItemV2 struct {
+	ID    uint
+	Name  string `gorm:"size:64"`
+	Price uint
+}
+
+func (PlannedItemV2) TableName() string {
+	return "planned_items"
+}
+
+func TestPlan(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	plan, err := immugorm.Plan(db, &PlannedItem{})
+	require.NoError(t, err)
+	require.NoError(t, plan.Err())
+	require.Len(t, plan.Statements, 1)
+	require.Contains(t, plan.Statements[0], "CREATE TABLE planned_items")
+	require.False(t, db.Migrator().HasTable(&PlannedItem{}))
+
+	err = db.AutoMigrate(&PlannedItem{})
+	require.NoError(t, err)
+
+	plan, err = immugorm.Plan(db, &PlannedItem{})
+	require.NoError(t, err)
+	require.NoError(t, plan.Err())
+	require.Empty(t, plan.Statements)
+
+	plan, err = immugorm.Plan(db, &PlannedItemV2{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ALTER TABLE planned_items ADD COLUMN price INTEGER"}, plan.Statements)
+	require.Equal(t, []immugorm.SchemaChange{
+		{Table: "planned_items", Column: "name", Reason: "size change from 32 to 64"},
+		{Table: "planned_items", Column: "note", Reason: "column dropped"},
+	}, plan.Unsupported)
+	require.ErrorIs(t, plan.Err(), immugorm.ErrUnsupportedSchemaChange)
+	require.False(t, db.Migrator().HasColumn(&PlannedItemV2{}, "price"))
+	require.True(t, db.Migrator().HasColumn(&PlannedItemV2{}, "name"))
+}
+
+type PlannedSlot struct {
+	ID    uint
+	Zone  string `gorm:"size:16;index"`
+	Shelf uint
+}
+
+type PlannedSlotV2 struct {
+	ID    uint
+	Zone  string `gorm:"size:16;index;index:idx_zone_shelf"`
+	Shelf uint   `gorm:"index:idx_zone_shelf"`
+}
+
+func (PlannedSlotV2) TableName() string {
+	return "planned_slots"
+}
+
+func TestPlanCompositeIndex(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&PlannedSlot{})
+	require.NoError(t, err)
+
+	// the index on the leading column is not the composite index
+	require.False(t, db.Migrator().HasIndex(&PlannedSlotV2{}, "idx_zone_shelf"))
+	plan, err := immugorm.Plan(db, &PlannedSlotV2{})
+	require.NoError(t, err)
+	require.NoError(t, plan.Err())
+	require.Equal(t, []string{"CREATE INDEX ON planned_slots (zone,shelf)"}, plan.Statements)
+
+	err = db.AutoMigrate(&PlannedSlotV2{})
+	require.NoError(t, err)
+	require.True(t, db.Migrator().HasIndex(&PlannedSlotV2{}, "idx_zone_shelf"))
+	plan, err = immugorm.Plan(db, &PlannedSlotV2{})
+	require.NoError(t, err)
+	require.Empty(t, plan.Statements)
+}
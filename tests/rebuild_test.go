@@ -0,0 +1,189 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"fmt"
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type Article struct {
+	ID    uint
+	Title string `gorm:"index;size:64"`
+	Code  int
+	Notes string
+}
+
+type ArticleV2 struct {
+	ID    uint
+	Title string `gorm:"index;size:64"`
+	Code  string `gorm:"size:16"`
+}
+
+func (ArticleV2) TableName() string {
+	return "articles"
+}
+
+func TestTableRebuild(t *testing.T) {
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{TableRebuild: true, RebuildBatchSize: 3})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Article{})
+	require.NoError(t, err)
+
+	for i := 1; i <= 7; i++ {
+		err = db.Create(&Article{Title: fmt.Sprintf("title-%d", i), Code: i * 10, Notes: "notes"}).Error
+		require.NoError(t, err)
+	}
+
+	err = db.Migrator().DropColumn(&Article{}, "Notes")
+	require.NoError(t, err)
+	err = db.Migrator().AlterColumn(&ArticleV2{}, "Code")
+	require.NoError(t, err)
+
+	require.True(t, db.Migrator().HasTable("articles_v3"))
+	require.False(t, db.Migrator().HasColumn(&ArticleV2{}, "notes"))
+	require.True(t, db.Migrator().HasIndex(&ArticleV2{}, "idx_articles_title"))
+
+	var articles []ArticleV2
+	err = db.Find(&articles).Error
+	require.NoError(t, err)
+	require.Len(t, articles, 7)
+	require.Equal(t, "title-7", articles[6].Title)
+	require.Equal(t, "70", articles[6].Code)
+
+	err = db.Create(&ArticleV2{Title: "title-8", Code: "80"}).Error
+	require.NoError(t, err)
+
+	var article ArticleV2
+	err = db.First(&article, "title = ?", "title-8").Error
+	require.NoError(t, err)
+	require.Equal(t, uint(8), article.ID)
+
+	err = db.Model(&article).Update("Code", "81").Error
+	require.NoError(t, err)
+	err = db.Delete(&ArticleV2{}, 1).Error
+	require.NoError(t, err)
+
+	// the mapping is loaded by new connections
+	db2, err := immugorm.UseDatabase(db, "defaultdb")
+	require.NoError(t, err)
+	var count int64
+	err = db2.Model(&ArticleV2{}).Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(7), count)
+
+	// the original table keeps its rows
+	var original int64
+	err = db2.Table("articles_v2").Count(&original).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(7), original)
+}
+
+type Stock struct {
+	Warehouse string `gorm:"primaryKey;size:16"`
+	Sku       int    `gorm:"primaryKey;autoIncrement:false"`
+	Quantity  int
+	Notes     string
+}
+
+func TestTableRebuildCompositeKey(t *testing.T) {
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{TableRebuild: true, RebuildBatchSize: 4})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Stock{})
+	require.NoError(t, err)
+
+	for _, warehouse := range []string{"north", "south"} {
+		for sku := 1; sku <= 5; sku++ {
+			err = db.Create(&Stock{Warehouse: warehouse, Sku: sku, Quantity: sku * 10, Notes: "notes"}).Error
+			require.NoError(t, err)
+		}
+	}
+
+	err = db.Migrator().DropColumn(&Stock{}, "Notes")
+	require.NoError(t, err)
+
+	var stocks []Stock
+	err = db.Order("warehouse").Find(&stocks).Error
+	require.NoError(t, err)
+	require.Len(t, stocks, 10)
+	require.Equal(t, "south", stocks[9].Warehouse)
+	require.Equal(t, 5, stocks[9].Sku)
+	require.Equal(t, 50, stocks[9].Quantity)
+	require.Empty(t, stocks[9].Notes)
+}
+
+func TestTableRebuildLeftover(t *testing.T) {
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{TableRebuild: true, RebuildBatchSize: 3})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Article{})
+	require.NoError(t, err)
+	for i := 1; i <= 7; i++ {
+		err = db.Create(&Article{Title: fmt.Sprintf("title-%d", i), Code: i * 10, Notes: "notes"}).Error
+		require.NoError(t, err)
+	}
+
+	// a rebuild that failed after creating its table and copying the first rows is resumed
+	err = db.Exec("CREATE TABLE articles_v2 (id INTEGER AUTO_INCREMENT, title VARCHAR[64], code INTEGER, PRIMARY KEY (id))").Error
+	require.NoError(t, err)
+	err = db.Exec("CREATE INDEX ON articles_v2 (title)").Error
+	require.NoError(t, err)
+	err = db.Exec("INSERT INTO articles_v2 (id, title, code) VALUES (1, 'title-1', 10), (2, 'title-2', 20)").Error
+	require.NoError(t, err)
+
+	err = db.Migrator().DropColumn(&Article{}, "Notes")
+	require.NoError(t, err)
+	require.False(t, db.Migrator().HasTable("articles_v3"))
+
+	var count int64
+	err = db.Table("articles_v2").Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(7), count)
+
+	// a table left with other columns is skipped
+	err = db.Exec("CREATE TABLE articles_v3 (id INTEGER AUTO_INCREMENT, title VARCHAR[64], PRIMARY KEY (id))").Error
+	require.NoError(t, err)
+
+	err = db.Migrator().AlterColumn(&ArticleV2{}, "Code")
+	require.NoError(t, err)
+	require.True(t, db.Migrator().HasTable("articles_v4"))
+
+	var articles []ArticleV2
+	err = db.Find(&articles).Error
+	require.NoError(t, err)
+	require.Len(t, articles, 7)
+	require.Equal(t, "70", articles[6].Code)
+}
+
+func TestTableRebuildDisabled(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Article{})
+	require.NoError(t, err)
+
+	err = db.Migrator().DropColumn(&Article{}, "Notes")
+	require.ErrorIs(t, err, immugorm.ErrNotImplemented)
+}
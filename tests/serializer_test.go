@@ -0,0 +1,113 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type ProfileAddress struct {
+	Street string
+	City   string
+}
+
+type ProfilePreferences struct {
+	Theme  string
+	Alerts []string
+}
+
+type Profile struct {
+	ID          uint
+	Attributes  map[string]interface{} `gorm:"serializer:json"`
+	Address     ProfileAddress         `gorm:"serializer:json;size:512"`
+	Tags        []string               `gorm:"serializer:json"`
+	Preferences ProfilePreferences     `gorm:"serializer:gob"`
+	SeenAt      int64                  `gorm:"serializer:unixtime"`
+}
+
+func TestSerializer(t *testing.T) {
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{Verify: true})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Profile{})
+	require.NoError(t, err)
+
+	columnTypes, err := db.Migrator().ColumnTypes(&Profile{})
+	require.NoError(t, err)
+	types := map[string]string{}
+	for _, c := range columnTypes {
+		types[c.Name()] = c.DatabaseTypeName()
+	}
+	require.Equal(t, "VARCHAR", types["attributes"])
+	require.Equal(t, "VARCHAR", types["address"])
+	require.Equal(t, "BLOB", types["preferences"])
+	require.Equal(t, "TIMESTAMP", types["seen_at"])
+
+	profile := Profile{
+		Attributes:  map[string]interface{}{"plan": "pro", "seats": float64(5)},
+		Address:     ProfileAddress{Street: "Main Street 1", City: "Springfield"},
+		Tags:        []string{"admin", "beta"},
+		Preferences: ProfilePreferences{Theme: "dark", Alerts: []string{"email"}},
+		SeenAt:      time.Date(2021, 12, 1, 10, 0, 0, 0, time.UTC).Unix(),
+	}
+	err = db.Create(&profile).Error
+	require.NoError(t, err)
+
+	var stored Profile
+	err = db.First(&stored, profile.ID).Error
+	require.NoError(t, err)
+	require.Equal(t, profile, stored)
+
+	stored.Tags = append(stored.Tags, "support")
+	stored.Preferences.Theme = "light"
+	err = db.Save(&stored).Error
+	require.NoError(t, err)
+
+	var saved Profile
+	err = db.First(&saved, profile.ID).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"admin", "beta", "support"}, saved.Tags)
+	require.Equal(t, "light", saved.Preferences.Theme)
+
+	err = db.Model(&saved).Updates(map[string]interface{}{
+		"tags":    []string{"owner"},
+		"address": ProfileAddress{City: "Shelbyville"},
+	}).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"owner"}, saved.Tags)
+
+	var updated Profile
+	err = db.First(&updated, profile.ID).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"owner"}, updated.Tags)
+	require.Equal(t, ProfileAddress{City: "Shelbyville"}, updated.Address)
+
+	empty := Profile{}
+	err = db.Create(&empty).Error
+	require.NoError(t, err)
+
+	var storedEmpty Profile
+	err = db.First(&storedEmpty, empty.ID).Error
+	require.NoError(t, err)
+	require.Nil(t, storedEmpty.Attributes)
+	require.Empty(t, storedEmpty.Tags)
+	require.Equal(t, ProfilePreferences{}, storedEmpty.Preferences)
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"testing"
+)
+
+type Shopper struct {
+	ID      uint
+	Name    string `gorm:"size:32;index"`
+	Country string `gorm:"size:32;index"`
+}
+
+type Purchase struct {
+	ID        uint
+	ShopperID uint `gorm:"index"`
+	Amount    int
+}
+
+func shopperNames(shoppers []Shopper) []string {
+	names := make([]string, len(shoppers))
+	for i, shopper := range shoppers {
+		names[i] = shopper.Name
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSubqueries(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Shopper{}, &Purchase{})
+	require.NoError(t, err)
+
+	shoppers := []Shopper{{Name: "alice", Country: "IT"}, {Name: "bob", Country: "FR"}, {Name: "carol", Country: "IT"}}
+	err = db.Create(&shoppers).Error
+	require.NoError(t, err)
+	purchases := []Purchase{
+		{ShopperID: shoppers[0].ID, Amount: 10},
+		{ShopperID: shoppers[0].ID, Amount: 70},
+		{ShopperID: shoppers[1].ID, Amount: 20},
+	}
+	err = db.Create(&purchases).Error
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		query    func(tx *gorm.DB) *gorm.DB
+		expected []string
+	}{
+		{"in", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("id IN (?)", db.Model(&Purchase{}).Select("shopper_id"))
+		}, []string{"alice", "bob"}},
+		{"in without parentheses", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("id IN ?", db.Model(&Purchase{}).Select("shopper_id").Where("amount > ?", 50))
+		}, []string{"alice"}},
+		{"not in", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("id NOT IN (?)", db.Model(&Purchase{}).Select("shopper_id"))
+		}, []string{"carol"}},
+		{"in empty", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("id IN (?)", db.Model(&Purchase{}).Select("shopper_id").Where("amount > ?", 100))
+		}, []string{}},
+		{"not in empty", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("id NOT IN (?)", db.Model(&Purchase{}).Select("shopper_id").Where("amount > ?", 100))
+		}, []string{"alice", "bob", "carol"}},
+		{"named", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("country = @country AND id IN @ids", map[string]interface{}{
+				"country": "IT",
+				"ids":     db.Model(&Purchase{}).Select("shopper_id"),
+			})
+		}, []string{"alice"}},
+		{"scalar", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("id = (?)", db.Model(&Purchase{}).Select("shopper_id").Where("amount = ?", 20))
+		}, []string{"bob"}},
+		{"clause eq", func(tx *gorm.DB) *gorm.DB {
+			return tx.Clauses(clause.Eq{Column: "id", Value: db.Model(&Purchase{}).Select("shopper_id").Where("amount = ?", 20)})
+		}, []string{"bob"}},
+		{"clause eq empty", func(tx *gorm.DB) *gorm.DB {
+			return tx.Clauses(clause.Eq{Column: "id", Value: db.Model(&Purchase{}).Select("shopper_id").Where("amount = ?", 0)})
+		}, []string{}},
+		{"nested", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("id IN (?)", db.Model(&Purchase{}).Select("shopper_id").
+				Where("shopper_id IN (?)", db.Model(&Shopper{}).Select("id").Where("country = ?", "FR")))
+		}, []string{"bob"}},
+		{"or", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("name = ?", "carol").Or("id IN (?)", db.Model(&Purchase{}).Select("shopper_id").Where("amount > ?", 50))
+		}, []string{"alice", "carol"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var found []Shopper
+			err := tc.query(db.Model(&Shopper{})).Find(&found).Error
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, shopperNames(found))
+		})
+	}
+
+	// subqueries read as tables are run by immudb
+	var totals []struct {
+		ShopperID uint
+		Amount    int
+	}
+	err = db.Table("(?) AS p", db.Model(&Purchase{}).Select("shopper_id, amount").Where("amount > ?", 15)).
+		Where("p.amount < ?", 50).Find(&totals).Error
+	require.NoError(t, err)
+	require.Len(t, totals, 1)
+	require.Equal(t, shoppers[1].ID, totals[0].ShopperID)
+
+	// with subqueries in their conditions too
+	err = db.Table("(?) AS p", db.Model(&Purchase{}).Select("shopper_id, amount").
+		Where("shopper_id IN (?)", db.Model(&Shopper{}).Select("id").Where("name = ?", "alice"))).Find(&totals).Error
+	require.NoError(t, err)
+	require.Len(t, totals, 2)
+
+	// but not when they would be emulated on the client
+	err = db.Table("(?) AS p", db.Model(&Purchase{}).Select("shopper_id, amount").Order("amount")).Find(&totals).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedSubquery)
+
+	// the conditions of updates, deletes and counts
+	var count int64
+	err = db.Model(&Shopper{}).Where("id IN (?)", db.Model(&Purchase{}).Select("shopper_id")).Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(2), count)
+
+	err = db.Model(&Shopper{}).Where("id IN (?)", db.Model(&Purchase{}).Select("shopper_id").Where("amount > ?", 50)).
+		Update("country", "DE").Error
+	require.NoError(t, err)
+	err = db.Model(&Shopper{}).Where("country = ?", "DE").Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	err = db.Where("shopper_id IN (?)", db.Model(&Shopper{}).Select("id").Where("country = ?", "FR")).Delete(&Purchase{}).Error
+	require.NoError(t, err)
+	err = db.Model(&Purchase{}).Count(&count).Error
+	require.NoError(t, err)
+	require.Equal(t, int64(2), count)
+
+	// correlated subqueries cannot run on their own
+	var found []Shopper
+	err = db.Where("id IN (?)", db.Model(&Purchase{}).Select("shopper_id").Where("purchases.shopper_id = shoppers.id")).Find(&found).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedSubquery)
+
+	err = db.Where("EXISTS (?)", db.Model(&Purchase{}).Select("id").Where("purchases.shopper_id = shoppers.id")).Find(&found).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedSubquery)
+
+	// so are those naming a column of the outer table without qualifying it
+	err = db.Where("id IN (?)", db.Model(&Purchase{}).Select("shopper_id").Where("amount > 0 AND country = ?", "IT")).Find(&found).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedSubquery)
+	require.Contains(t, err.Error(), "references the column country of the table shoppers")
+
+	// the names of columns of the subquery table are resolved against it
+	err = db.Where("id IN (?)", db.Table("purchases").Select("shopper_id").Where("shopper_id = id")).Find(&found).Error
+	require.NoError(t, err)
+
+	// the aliases of table expressions tell a self correlation apart from a subquery on the same table
+	var amounts []int
+	err = db.Table("purchases p1").Where("p1.amount = (?)", db.Table("purchases p2").Select("max(p2.amount)").Where("p2.shopper_id = p1.shopper_id")).Pluck("p1.amount", &amounts).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedSubquery)
+	require.Contains(t, err.Error(), "the subquery on table purchases p2 references the table purchases p1 of the outer query")
+
+	err = db.Table("purchases AS p1").Where("p1.amount = (?)", db.Table("purchases AS p2").Select("max(p2.amount)")).Pluck("p1.amount", &amounts).Error
+	require.NoError(t, err)
+	require.Len(t, amounts, 1)
+
+	err = db.Where("id IN (?)", db.Model(&Purchase{}).Select("shopper_id, amount")).Find(&found).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedSubquery)
+
+	err = db.Where("id = (?)", db.Model(&Purchase{}).Select("shopper_id")).Find(&found).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedSubquery)
+}
+
+func TestSubqueryLimit(t *testing.T) {
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{SubqueryLimit: 2})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Shopper{})
+	require.NoError(t, err)
+	err = db.Create(&[]Shopper{{Name: "alice", Country: "IT"}, {Name: "bob", Country: "FR"}, {Name: "carol", Country: "IT"}}).Error
+	require.NoError(t, err)
+
+	var found []Shopper
+	err = db.Where("id IN (?)", db.Model(&Shopper{}).Select("id").Where("country = ?", "IT")).Find(&found).Error
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "carol"}, shopperNames(found))
+
+	err = db.Where("id IN (?)", db.Model(&Shopper{}).Select("id")).Find(&found).Error
+	require.ErrorIs(t, err, immugorm.ErrUnsupportedSubquery)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"bytes"
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"testing"
+)
+
+type EventLog struct {
+	Level   string `gorm:"size:16"`
+	Message string
+	Code    *int
+}
+
+type SensorReading struct {
+	Sensor string `gorm:"size:16"`
+	Value  float64
+	Note   string  `immudb:"encrypted"`
+	Tag    *string `gorm:"size:16" immudb:"encrypted,deterministic"`
+}
+
+func TestSurrogateKey(t *testing.T) {
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{SurrogateKey: true, Verify: true})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&EventLog{})
+	require.NoError(t, err)
+	require.True(t, db.Migrator().HasColumn(&EventLog{}, immugorm.SurrogateKeyColumn))
+
+	plan, err := immugorm.Plan(db, &EventLog{})
+	require.NoError(t, err)
+	require.NoError(t, plan.Err())
+
+	code := 1
+	err = db.Create(&[]EventLog{
+		{Level: "info", Message: "started"},
+		{Level: "warn", Message: "slow"},
+		{Level: "warn", Message: "slow"},
+		{Level: "error", Message: "failed", Code: &code},
+	}).Error
+	require.NoError(t, err)
+
+	var logs []EventLog
+	err = db.Find(&logs).Error
+	require.NoError(t, err)
+	require.Len(t, logs, 4)
+
+	var failed EventLog
+	err = db.Where("level = ?", "error").First(&failed).Error
+	require.NoError(t, err)
+	require.Equal(t, 1, *failed.Code)
+
+	res := db.Model(&logs[0]).Update("message", "restarted")
+	require.NoError(t, res.Error)
+	require.Equal(t, int64(1), res.RowsAffected)
+	require.Equal(t, "restarted", logs[0].Message)
+
+	// equal records cannot be told apart
+	err = db.Delete(&logs[1]).Error
+	require.ErrorIs(t, err, immugorm.ErrAmbiguousRecord)
+	err = db.Model(&logs[2]).Update("message", "slower").Error
+	require.ErrorIs(t, err, immugorm.ErrAmbiguousRecord)
+
+	res = db.Where("message = ?", "slow").Delete(&EventLog{})
+	require.NoError(t, res.Error)
+	require.Equal(t, int64(2), res.RowsAffected)
+
+	res = db.Delete(&failed)
+	require.NoError(t, res.Error)
+	require.Equal(t, int64(1), res.RowsAffected)
+
+	// already deleted
+	err = db.Delete(&failed).Error
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	err = db.Delete(&EventLog{}).Error
+	require.ErrorIs(t, err, gorm.ErrMissingWhereClause)
+
+	logs = nil
+	err = db.Find(&logs).Error
+	require.NoError(t, err)
+	require.Equal(t, []EventLog{{Level: "info", Message: "restarted"}}, logs)
+}
+
+func TestSurrogateKeyStoredValues(t *testing.T) {
+	key := immugorm.StaticKey(bytes.Repeat([]byte{0x42}, 32))
+	db, close, err := OpenDBWithConfig(&immugorm.ImmuGormConfig{SurrogateKey: true, KeyProvider: key})
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&SensorReading{})
+	require.NoError(t, err)
+
+	tag := "outdoor"
+	err = db.Create(&[]SensorReading{
+		{Sensor: "t1", Value: 21.5, Note: "calibrated", Tag: &tag},
+		{Sensor: "t2", Value: -3.25, Note: "calibrated", Tag: &tag},
+	}).Error
+	require.NoError(t, err)
+
+	var readings []SensorReading
+	err = db.Where("sensor = ?", "t1").Find(&readings).Error
+	require.NoError(t, err)
+	require.Len(t, readings, 1)
+
+	// floats and encrypted columns still identify the record
+	res := db.Model(&readings[0]).Update("note", "recalibrated")
+	require.NoError(t, res.Error)
+	require.Equal(t, int64(1), res.RowsAffected)
+
+	err = db.Where("sensor = ?", "t2").Find(&readings).Error
+	require.NoError(t, err)
+	res = db.Delete(&readings[0])
+	require.NoError(t, res.Error)
+	require.Equal(t, int64(1), res.RowsAffected)
+
+	// a record which is not stored is not found
+	err = db.Delete(&SensorReading{Sensor: "t3", Value: 1.5}).Error
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	readings = nil
+	err = db.Find(&readings).Error
+	require.NoError(t, err)
+	require.Equal(t, []SensorReading{{Sensor: "t1", Value: 21.5, Note: "recalibrated", Tag: &tag}}, readings)
+}
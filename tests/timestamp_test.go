@@ -17,9 +17,11 @@ limitations under the License.
 package tests
 
 import (
+	immugorm "github.com/codenotary/immugorm"
 	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 	"testing"
+	"time"
 )
 
 type Product struct {
@@ -53,3 +55,66 @@ func TestTimestamp(t *testing.T) {
 	db.Where("Code = 'D42'").Find(&prods)
 	require.Equal(t, 0, len(prods))
 }
+
+type Appointment struct {
+	ID        uint
+	At        time.Time `gorm:"index"`
+	EndsAt    *time.Time
+	CreatedAt time.Time
+}
+
+func TestTimestampPrecision(t *testing.T) {
+	opts, close := StartServer()
+	defer close()
+
+	db, err := Open(opts, &immugorm.ImmuGormConfig{Verify: true})
+	require.NoError(t, err)
+
+	err = db.AutoMigrate(&Appointment{})
+	require.NoError(t, err)
+
+	cet := time.FixedZone("CET", 3600)
+	times := []time.Time{
+		time.Now(),
+		time.Date(2021, 12, 31, 23, 59, 59, 999999999, cet),
+		time.Date(2022, 1, 1, 0, 0, 0, 1000, time.UTC),
+		time.Date(2022, 1, 1, 0, 0, 0, 999, time.UTC),
+		time.Date(1969, 12, 31, 23, 59, 59, 500000001, time.UTC),
+		time.Unix(0, 0),
+		time.Date(9999, 12, 31, 23, 59, 59, 999999999, time.UTC),
+	}
+
+	for _, at := range times {
+		endsAt := at.Add(time.Hour)
+		appointment := Appointment{At: at, EndsAt: &endsAt}
+		err = db.Create(&appointment).Error
+		require.NoError(t, err)
+
+		expected := at.Truncate(immugorm.TimestampPrecision).UTC()
+		require.Equal(t, expected, appointment.At)
+		require.Equal(t, time.UTC, appointment.CreatedAt.Location())
+		require.Equal(t, appointment.CreatedAt, appointment.CreatedAt.Truncate(immugorm.TimestampPrecision))
+
+		var stored Appointment
+		err = db.First(&stored, appointment.ID).Error
+		require.NoError(t, err)
+		require.Equal(t, appointment, stored)
+
+		var found Appointment
+		err = db.Where("at = ?", at).First(&found).Error
+		require.NoError(t, err)
+		require.Equal(t, appointment.ID, found.ID)
+	}
+
+	cest := time.FixedZone("CEST", 7200)
+	zoned, err := Open(opts, &immugorm.ImmuGormConfig{TimeZone: cest})
+	require.NoError(t, err)
+
+	var appointment Appointment
+	err = zoned.First(&appointment, 2).Error
+	require.NoError(t, err)
+	require.Equal(t, cest, appointment.At.Location())
+	require.Equal(t, cest, appointment.EndsAt.Location())
+	require.True(t, appointment.At.Equal(times[1].Truncate(immugorm.TimestampPrecision)))
+	require.Equal(t, 0, appointment.At.Hour())
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"testing"
+	"time"
+)
+
+type Setting struct {
+	Name    string `gorm:"primaryKey;size:32"`
+	Value   string
+	Version int
+}
+
+type StockItem struct {
+	ID        uint
+	SKU       string `gorm:"size:32;uniqueIndex"`
+	Name      string
+	Stock     int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func TestUpsert(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&Setting{})
+	require.NoError(t, err)
+
+	err = db.Create(&[]Setting{{Name: "theme", Value: "dark", Version: 1}, {Name: "lang", Value: "en", Version: 1}}).Error
+	require.NoError(t, err)
+
+	upsert := db.Clauses(clause.OnConflict{UpdateAll: true})
+	stmt := upsert.Session(&gorm.Session{DryRun: true}).Create(&Setting{Name: "theme", Value: "light"}).Statement
+	require.Contains(t, stmt.SQL.String(), "UPSERT INTO")
+	require.NotContains(t, stmt.SQL.String(), "ON CONFLICT")
+
+	err = upsert.Create(&[]Setting{{Name: "theme", Value: "light", Version: 2}, {Name: "tz", Value: "UTC", Version: 1}}).Error
+	require.NoError(t, err)
+
+	var settings []Setting
+	err = db.Order("name").Find(&settings).Error
+	require.NoError(t, err)
+	require.Equal(t, []Setting{
+		{Name: "lang", Value: "en", Version: 1},
+		{Name: "theme", Value: "light", Version: 2},
+		{Name: "tz", Value: "UTC", Version: 1},
+	}, settings)
+
+	// only the assigned columns are updated
+	err = db.Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"version"})}).
+		Create(&Setting{Name: "lang", Value: "fr", Version: 3}).Error
+	require.NoError(t, err)
+
+	err = db.Clauses(clause.OnConflict{DoNothing: true}).Create(&Setting{Name: "tz", Value: "CET", Version: 9}).Error
+	require.NoError(t, err)
+
+	settings = nil
+	err = db.Order("name").Find(&settings).Error
+	require.NoError(t, err)
+	require.Equal(t, Setting{Name: "lang", Value: "en", Version: 3}, settings[0])
+	require.Equal(t, Setting{Name: "tz", Value: "UTC", Version: 1}, settings[2])
+}
+
+func TestUpsertEmulation(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&StockItem{})
+	require.NoError(t, err)
+
+	pen := StockItem{SKU: "pen", Name: "Pen", Stock: 10}
+	err = db.Create(&pen).Error
+	require.NoError(t, err)
+
+	// the creation time of the conflicting row is kept
+	replaced := StockItem{ID: pen.ID, SKU: "pen", Name: "Blue pen", Stock: 5, CreatedAt: pen.CreatedAt.Add(time.Hour)}
+	err = db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&replaced).Error
+	require.NoError(t, err)
+
+	var stored StockItem
+	err = db.First(&stored, pen.ID).Error
+	require.NoError(t, err)
+	require.Equal(t, "Blue pen", stored.Name)
+	require.Equal(t, 5, stored.Stock)
+	require.Equal(t, pen.CreatedAt, stored.CreatedAt)
+
+	// conflicts on a unique column, the keys of the records are filled
+	products := []StockItem{{SKU: "pen", Name: "Red pen", Stock: 3}, {SKU: "ink", Name: "Ink", Stock: 7}}
+	res := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "sku"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"stock": gorm.Expr("stock + ?", 3)}),
+	}).Create(&products)
+	require.NoError(t, res.Error)
+	require.Equal(t, int64(2), res.RowsAffected)
+	require.Equal(t, pen.ID, products[0].ID)
+	require.NotZero(t, products[1].ID)
+	require.NotEqual(t, pen.ID, products[1].ID)
+
+	var stock []StockItem
+	err = db.Order("id").Find(&stock).Error
+	require.NoError(t, err)
+	require.Len(t, stock, 2)
+	require.Equal(t, "Blue pen", stock[0].Name)
+	require.Equal(t, 8, stock[0].Stock)
+	require.Equal(t, "Ink", stock[1].Name)
+	require.Equal(t, 7, stock[1].Stock)
+
+	// inside a transaction the rows are written with it
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "sku"}}, DoUpdates: clause.AssignmentColumns([]string{"stock"})}).
+			Create(&StockItem{SKU: "ink", Name: "Black ink", Stock: 1}).Error
+	})
+	require.NoError(t, err)
+
+	stored = StockItem{}
+	err = db.Where("sku = ?", "ink").First(&stored).Error
+	require.NoError(t, err)
+	require.Equal(t, "Ink", stored.Name)
+	require.Equal(t, 1, stored.Stock)
+}
+
+type PriceTag struct {
+	ID    uint
+	SKU   string `gorm:"size:32;uniqueIndex"`
+	Price float64
+}
+
+func TestUpsertFloat(t *testing.T) {
+	db, close, err := OpenDB()
+	require.NoError(t, err)
+	defer close()
+
+	err = db.AutoMigrate(&PriceTag{})
+	require.NoError(t, err)
+
+	err = db.Create(&PriceTag{SKU: "pen", Price: 1.5}).Error
+	require.NoError(t, err)
+
+	// emulated on the unique column, the inserted and the assigned floats are encoded
+	tags := []PriceTag{{SKU: "pen", Price: 2.25}, {SKU: "ink", Price: -0.75}}
+	err = db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "sku"}}, DoUpdates: clause.AssignmentColumns([]string{"price"})}).Create(&tags).Error
+	require.NoError(t, err)
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "sku"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"price": 3.5}),
+	}).Create(&PriceTag{SKU: "ink", Price: 9}).Error
+	require.NoError(t, err)
+
+	// replaced through UPSERT on the primary key
+	err = db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&PriceTag{ID: tags[0].ID, SKU: "pen", Price: 4.125}).Error
+	require.NoError(t, err)
+
+	var stored []PriceTag
+	err = db.Order("id").Find(&stored).Error
+	require.NoError(t, err)
+	require.Equal(t, []PriceTag{{ID: tags[0].ID, SKU: "pen", Price: 4.125}, {ID: tags[1].ID, SKU: "ink", Price: 3.5}}, stored)
+}
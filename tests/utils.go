@@ -28,6 +28,20 @@ import (
 )
 
 func OpenDB() (*gorm.DB, func(), error) {
+	return OpenDBWithConfig(nil)
+}
+
+// OpenDBWithConfig starts an immudb server and opens a database on it, unverified if cfg is nil. The returned function
+// stops the server and removes its data.
+func OpenDBWithConfig(cfg *immudb.ImmuGormConfig) (*gorm.DB, func(), error) {
+	opts, close := StartServer()
+	db, err := Open(opts, cfg)
+	return db, close, err
+}
+
+// StartServer starts an immudb server and returns the options to connect to it, and the function stopping it and
+// removing its data and the state of its clients
+func StartServer() (*client.Options, func()) {
 	options := server.DefaultOptions()
 	bs := servertest.NewBufconnServer(options)
 	bs.Start()
@@ -39,19 +53,22 @@ func OpenDB() (*gorm.DB, func(), error) {
 	opts.Username = "immudb"
 	opts.Password = "immudb"
 	opts.Database = "defaultdb"
-
-	db, err := gorm.Open(immudb.OpenWithOptions(opts, &immudb.ImmuGormConfig{Verify: false}), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
-	})
-	if err != nil {
-
-	}
+	opts.Dir = options.Dir
 
 	close := func() {
 		bs.Stop()
 		os.RemoveAll(options.Dir)
-		os.Remove(".state-")
 	}
 
-	return db, close, err
+	return opts, close
+}
+
+// Open opens a database on the server of opts, unverified if cfg is nil
+func Open(opts *client.Options, cfg *immudb.ImmuGormConfig) (*gorm.DB, error) {
+	if cfg == nil {
+		cfg = &immudb.ImmuGormConfig{Verify: false}
+	}
+	return gorm.Open(immudb.OpenWithOptions(opts, cfg), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	})
 }
@@ -0,0 +1,123 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"database/sql/driver"
+	immugorm "github.com/codenotary/immugorm"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type DeviceID [16]byte
+
+// TraceID is a UUID kept in its canonical form
+type TraceID string
+
+func (TraceID) GormDataType() string {
+	return "uuid"
+}
+
+func (id TraceID) Value() (driver.Value, error) {
+	return string(id), nil
+}
+
+type Device struct {
+	ID      DeviceID
+	Name    string  `gorm:"size:64"`
+	TraceID TraceID `gorm:"default:gen_random_uuid()"`
+}
+
+type DeviceReading struct {
+	ID       [16]byte
+	DeviceID DeviceID `gorm:"index"`
+	Device   Device
+	Value    int
+}
+
+func TestUUID(t *testing.T) {
+	opts, close := StartServer()
+	defer close()
+
+	db, err := Open(opts, &immugorm.ImmuGormConfig{Verify: true})
+	require.NoError(t, err)
+
+	err = db.AutoMigrate(&Device{}, &DeviceReading{})
+	require.NoError(t, err)
+
+	plan, err := immugorm.Plan(db, &Device{}, &DeviceReading{})
+	require.NoError(t, err)
+	require.NoError(t, plan.Err())
+	require.Empty(t, plan.Statements)
+
+	columnTypes, err := db.Migrator().ColumnTypes(&Device{})
+	require.NoError(t, err)
+	for _, c := range columnTypes {
+		if c.Name() == "id" || c.Name() == "trace_id" {
+			require.Equal(t, "BLOB", c.DatabaseTypeName())
+			length, _ := c.Length()
+			require.Equal(t, int64(16), length)
+		}
+	}
+
+	devices := []Device{{Name: "sensor"}, {Name: "gateway"}}
+	err = db.Create(&devices).Error
+	require.NoError(t, err)
+	require.NotEqual(t, DeviceID{}, devices[0].ID)
+	require.NotEqual(t, devices[0].ID, devices[1].ID)
+	require.Equal(t, byte(0x40), devices[0].ID[6]&0xf0)
+	require.Len(t, string(devices[0].TraceID), 36)
+	require.NotEqual(t, devices[0].TraceID, devices[1].TraceID)
+
+	var device Device
+	err = db.First(&device, devices[1].ID).Error
+	require.NoError(t, err)
+	require.Equal(t, devices[1], device)
+
+	var traced Device
+	err = db.Where("trace_id = ?", devices[0].TraceID).First(&traced).Error
+	require.NoError(t, err)
+	require.Equal(t, devices[0], traced)
+
+	reading := DeviceReading{DeviceID: devices[0].ID, Value: 42}
+	err = db.Create(&reading).Error
+	require.NoError(t, err)
+	require.NotEqual(t, [16]byte{}, reading.ID)
+
+	var readings []DeviceReading
+	err = db.Preload("Device").Where(&DeviceReading{DeviceID: devices[0].ID}).Find(&readings).Error
+	require.NoError(t, err)
+	require.Len(t, readings, 1)
+	require.Equal(t, reading.ID, readings[0].ID)
+	require.Equal(t, devices[0], readings[0].Device)
+
+	err = db.Model(&devices[0]).Update("name", "thermometer").Error
+	require.NoError(t, err)
+
+	plain, err := Open(opts, &immugorm.ImmuGormConfig{})
+	require.NoError(t, err)
+
+	// the first transaction the device exists in holds its original name
+	var original Device
+	for tx := uint64(2); original.Name == ""; tx++ {
+		err = plain.Clauses(immugorm.BeforeTx(tx)).Find(&original, devices[0].ID).Error
+		require.NoError(t, err)
+		require.Less(t, tx, uint64(100))
+	}
+	require.Equal(t, "sensor", original.Name)
+	require.Equal(t, devices[0].ID, original.ID)
+}
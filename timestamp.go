@@ -0,0 +1,113 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"time"
+)
+
+// immudb stores TIMESTAMP values as microseconds since the Unix epoch, dropping the location, the monotonic clock
+// reading and the nanoseconds. Times are normalized the same way on write, so the values of the created and updated
+// records match what is read back, and returned in the configured time zone.
+
+// TimestampPrecision is the precision of the TIMESTAMP values stored by immudb
+const TimestampPrecision = time.Microsecond
+
+// normalizeTime returns t as immudb stores it, in the location loc
+func normalizeTime(t time.Time, loc *time.Location) time.Time {
+	return t.Truncate(TimestampPrecision).In(loc)
+}
+
+func (dialector *Dialector) timeZone() *time.Location {
+	if dialector.cfg != nil && dialector.cfg.TimeZone != nil {
+		return dialector.cfg.TimeZone
+	}
+	return time.UTC
+}
+
+// normalizeTimeVar maps time parameters to the value stored by immudb
+func normalizeTimeVar(v interface{}) (interface{}, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return normalizeTime(t, time.UTC), true
+	case *time.Time:
+		if t != nil {
+			return normalizeTime(*t, time.UTC), true
+		}
+	}
+	return v, false
+}
+
+// normalizeTimes normalizes the time fields of the created and updated records, and converts the time fields of
+// the queried records to the configured time zone
+func (dialector *Dialector) normalizeTimes(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+
+	fields := make([]*schema.Field, 0)
+	for _, field := range db.Statement.Schema.Fields {
+		if field.FieldType == timeType || field.FieldType == timePtrType {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	loc := dialector.timeZone()
+	normalize := func(rv reflect.Value) {
+		for _, field := range fields {
+			normalizeTimeField(db.Statement.Context, field, rv, loc)
+		}
+	}
+	switch db.Statement.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < db.Statement.ReflectValue.Len(); i++ {
+			if rv := reflect.Indirect(db.Statement.ReflectValue.Index(i)); rv.Kind() == reflect.Struct {
+				normalize(rv)
+			}
+		}
+	case reflect.Struct:
+		normalize(db.Statement.ReflectValue)
+	}
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	timePtrType = reflect.TypeOf(&time.Time{})
+)
+
+func normalizeTimeField(ctx context.Context, field *schema.Field, rv reflect.Value, loc *time.Location) {
+	fieldValue := field.ReflectValueOf(ctx, rv)
+	if !fieldValue.CanSet() {
+		return
+	}
+	switch t := fieldValue.Interface().(type) {
+	case time.Time:
+		fieldValue.Set(reflect.ValueOf(normalizeTime(t, loc)))
+	case *time.Time:
+		if t != nil {
+			normalized := normalizeTime(*t, loc)
+			fieldValue.Set(reflect.ValueOf(&normalized))
+		}
+	}
+}
@@ -0,0 +1,295 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+// excludedTable qualifies the inserted values in the assignments of ON CONFLICT clauses
+const excludedTable = "excluded"
+
+// create wraps the gorm create callback to update the rows conflicting with the inserted ones. immudb only supports
+// ON CONFLICT DO NOTHING: when the conflicting rows are replaced by the inserted ones the statement becomes an
+// UPSERT INTO, otherwise the rows are inserted one by one in a transaction, updating the conflicting ones.
+func (dialector *Dialector) create(create func(*gorm.DB)) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		stmt := db.Statement
+		c, ok := stmt.Clauses["ON CONFLICT"]
+		if onConflict, isOnConflict := c.Expression.(clause.OnConflict); db.Error != nil || !ok || !isOnConflict ||
+			onConflict.DoNothing || stmt.Schema == nil || stmt.SQL.Len() > 0 {
+			create(db)
+			return
+		}
+
+		if !stmt.Unscoped {
+			for _, c := range stmt.Schema.CreateClauses {
+				stmt.AddClause(c)
+			}
+		}
+		stmt.AddClauseIfNotExists(clause.Insert{})
+		values := callbacks.ConvertToCreateValues(stmt)
+		if db.Error != nil {
+			return
+		}
+		stmt.AddClause(values)
+
+		// gorm fills the assignments of UpdateAll while converting the values
+		onConflict, _ := stmt.Clauses["ON CONFLICT"].Expression.(clause.OnConflict)
+		switch {
+		case onConflict.DoNothing || len(onConflict.DoUpdates) == 0:
+			onConflict.DoNothing = true
+			stmt.AddClause(onConflict)
+		case isUpsert(stmt.Schema, values, onConflict):
+			delete(stmt.Clauses, "ON CONFLICT")
+			stmt.Clauses["INSERT"] = clause.Clause{Name: "UPSERT", Expression: stmt.Clauses["INSERT"].Expression}
+		case !db.DryRun:
+			dialector.emulateOnConflict(db, values, onConflict)
+			return
+		}
+		stmt.Build(stmt.BuildClauses...)
+		create(db)
+	}
+}
+
+// isUpsert reports whether the conflicting rows are replaced by the inserted ones, which is what UPSERT does: the
+// conflict is on the primary key, every row holds it and every other column is assigned its inserted value
+func isUpsert(s *schema.Schema, values clause.Values, onConflict clause.OnConflict) bool {
+	if len(s.PrimaryFields) == 0 || len(onConflict.Where.Exprs) > 0 || len(onConflict.TargetWhere.Exprs) > 0 ||
+		onConflict.OnConstraint != "" || !isPrimaryKeyTarget(s, onConflict.Columns) {
+		return false
+	}
+
+	positions := columnPositions(values.Columns)
+	for _, field := range s.PrimaryFields {
+		pos, ok := positions[field.DBName]
+		if !ok {
+			return false
+		}
+		for _, row := range values.Values {
+			if row[pos] == nil {
+				return false
+			}
+		}
+	}
+
+	assigned := make(map[string]bool, len(onConflict.DoUpdates))
+	for _, assignment := range onConflict.DoUpdates {
+		pos, ok := positions[assignment.Column.Name]
+		if !ok {
+			return false
+		}
+		if column, ok := assignment.Value.(clause.Column); ok {
+			if column.Table != excludedTable || column.Name != assignment.Column.Name {
+				return false
+			}
+		} else {
+			// like the update time of UpdateAll, the same value may be inserted
+			for _, row := range values.Values {
+				if !reflect.DeepEqual(row[pos], assignment.Value) {
+					return false
+				}
+			}
+		}
+		assigned[assignment.Column.Name] = true
+	}
+
+	// UPSERT sets the columns missing from the statement to NULL
+	for _, dbName := range s.DBNames {
+		if field := s.FieldsByDBName[dbName]; !field.PrimaryKey && !field.IgnoreMigration && !assigned[dbName] {
+			return false
+		}
+	}
+	return true
+}
+
+func isPrimaryKeyTarget(s *schema.Schema, columns []clause.Column) bool {
+	if len(columns) == 0 {
+		return true
+	}
+	if len(columns) != len(s.PrimaryFields) {
+		return false
+	}
+	for _, column := range columns {
+		if field := s.LookUpField(column.Name); field == nil || !field.PrimaryKey {
+			return false
+		}
+	}
+	return true
+}
+
+func columnPositions(columns []clause.Column) map[string]int {
+	positions := make(map[string]int, len(columns))
+	for i, column := range columns {
+		positions[column.Name] = i
+	}
+	return positions
+}
+
+// emulateOnConflict inserts the rows one by one in a transaction. The rows conflicting with a stored one on the
+// conflict columns, the primary key by default, update it with the assignments of the clause instead.
+// The statements run on the table without the model, so the float values are encoded first.
+func (dialector *Dialector) emulateOnConflict(db *gorm.DB, values clause.Values, onConflict clause.OnConflict) {
+	stmt := db.Statement
+	values = encodeFloatVars(stmt.Schema, values).(clause.Values)
+	onConflict.DoUpdates = encodeFloatVars(stmt.Schema, clause.Set(onConflict.DoUpdates)).(clause.Set)
+	targets := onConflict.Columns
+	if len(targets) == 0 {
+		for _, field := range stmt.Schema.PrimaryFields {
+			targets = append(targets, clause.Column{Name: field.DBName})
+		}
+	}
+	positions := columnPositions(values.Columns)
+
+	// the auto increment primary keys of the records are filled as gorm does
+	var records []reflect.Value
+	pk := stmt.Schema.PrioritizedPrimaryField
+	if pk != nil && pk.HasDefaultValue && pk.DefaultValueInterface == nil {
+		switch stmt.ReflectValue.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < stmt.ReflectValue.Len(); i++ {
+				records = append(records, reflect.Indirect(stmt.ReflectValue.Index(i)))
+			}
+		case reflect.Struct:
+			records = append(records, stmt.ReflectValue)
+		}
+	}
+	missingKey := func(i int) bool {
+		if i >= len(records) || records[i].Kind() != reflect.Struct {
+			return false
+		}
+		_, zero := pk.ValueOf(stmt.Context, records[i])
+		return zero
+	}
+
+	db.AddError(inTransaction(db, func(tx *gorm.DB) error {
+		for i, row := range values.Values {
+			conds := conflictConditions(targets, positions, row)
+
+			var n int64
+			if len(conds) > 0 {
+				if err := tx.Table(stmt.Table).Where(clause.Where{Exprs: conds}).Count(&n).Error; err != nil {
+					return err
+				}
+			}
+
+			if n == 0 {
+				if err := insertRow(tx, stmt.Table, values.Columns, row); err != nil {
+					return err
+				}
+				db.RowsAffected++
+				// the results of the statements of a transaction hold no last insert id
+				if missingKey(i) {
+					if err := readKey(stmt.Context, tx, stmt.Table, records[i], pk, nil); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			assignments := make(map[string]interface{}, len(onConflict.DoUpdates))
+			for _, assignment := range onConflict.DoUpdates {
+				value := assignment.Value
+				if column, ok := value.(clause.Column); ok && column.Table == excludedTable {
+					value = nil
+					if pos, ok := positions[column.Name]; ok {
+						value = row[pos]
+					}
+				}
+				assignments[assignment.Column.Name] = value
+			}
+			if len(onConflict.Where.Exprs) > 0 {
+				err := tx.Table(stmt.Table).Where(clause.Where{Exprs: conds}).Where(onConflict.Where).Count(&n).Error
+				if err != nil || n == 0 {
+					return err
+				}
+			}
+			update := tx.Table(stmt.Table).Where(clause.Where{Exprs: conds})
+			if len(onConflict.Where.Exprs) > 0 {
+				update = update.Where(onConflict.Where)
+			}
+			if err := update.UpdateColumns(assignments).Error; err != nil {
+				return err
+			}
+			db.RowsAffected += n
+
+			if missingKey(i) {
+				if err := readKey(stmt.Context, tx, stmt.Table, records[i], pk, conds); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+}
+
+// conflictConditions matches the conflict columns with the values of the row. Rows missing any of them, like the
+// ones without an auto increment key, conflict with no stored row.
+func conflictConditions(targets []clause.Column, positions map[string]int, row []interface{}) []clause.Expression {
+	conds := make([]clause.Expression, 0, len(targets))
+	for _, target := range targets {
+		pos, ok := positions[target.Name]
+		if !ok || row[pos] == nil {
+			return nil
+		}
+		conds = append(conds, clause.Eq{Column: clause.Column{Name: target.Name}, Value: row[pos]})
+	}
+	return conds
+}
+
+// readKey sets the auto increment key of the record to the one of the row matching conds, or to the last one
+func readKey(ctx context.Context, tx *gorm.DB, table string, record reflect.Value, pk *schema.Field, conds []clause.Expression) error {
+	query := tx.Table(table)
+	if len(conds) > 0 {
+		query = query.Where(clause.Where{Exprs: conds})
+	} else {
+		query = query.Order(clause.OrderByColumn{Column: clause.Column{Name: pk.DBName}, Desc: true})
+	}
+
+	var ids []int64
+	if err := query.Limit(1).Pluck(pk.DBName, &ids).Error; err != nil || len(ids) == 0 {
+		return err
+	}
+	return pk.Set(ctx, record, ids[0])
+}
+
+// insertRow inserts a single row, leaving out its NULL values: immudb fills the ones of auto increment columns
+func insertRow(tx *gorm.DB, table string, columns []clause.Column, row []interface{}) error {
+	values := clause.Values{Values: [][]interface{}{{}}}
+	for i, column := range columns {
+		if row[i] != nil {
+			values.Columns = append(values.Columns, column)
+			values.Values[0] = append(values.Values[0], row[i])
+		}
+	}
+
+	return tx.Exec("INSERT INTO ? ?", clause.Table{Name: table}, values).Error
+}
+
+// inTransaction runs f in the transaction of db, or in a new one: immudb has no savepoints to nest them
+func inTransaction(db *gorm.DB, f func(tx *gorm.DB) error) error {
+	tx := db.Session(&gorm.Session{NewDB: true})
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return f(tx)
+	}
+	return tx.Transaction(f)
+}
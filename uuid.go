@@ -0,0 +1,242 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// immudb has no UUID type. UUID fields, 16 byte arrays like uuid.UUID and types whose GormDataType is uuid, are
+// stored in BLOB[16] columns holding the bytes of the UUID. UUID query parameters are mapped the same way.
+
+// UUIDDataType is the gorm data type of UUID fields
+const UUIDDataType schema.DataType = "uuid"
+
+const uuidSize = 16
+
+var uuidDefaults = map[string]bool{
+	"gen_random_uuid()":  true,
+	"uuid_generate_v4()": true,
+	"uuid()":             true,
+}
+
+func isUUIDType(t reflect.Type) bool {
+	return t.Kind() == reflect.Array && t.Len() == uuidSize && t.Elem().Kind() == reflect.Uint8
+}
+
+func isUUIDField(field *schema.Field) bool {
+	if field.DataType == UUIDDataType {
+		return true
+	}
+	_, typed := field.TagSettings["TYPE"]
+	return !typed && field.Serializer == nil && isUUIDType(field.IndirectFieldType)
+}
+
+// parseUUID parses the canonical form of a UUID, with or without dashes and braces
+func parseUUID(s string) ([]byte, error) {
+	s = strings.Trim(s, "{}")
+	if strings.HasPrefix(strings.ToLower(s), "urn:uuid:") {
+		s = s[len("urn:uuid:"):]
+	}
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil || len(b) != uuidSize {
+		return nil, fmt.Errorf("invalid UUID %q", s)
+	}
+	return b, nil
+}
+
+func formatUUID(b []byte) string {
+	s := hex.EncodeToString(b)
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() ([]byte, error) {
+	b := make([]byte, uuidSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return b, nil
+}
+
+// uuidFieldValue converts the bytes of a UUID to a value that can be set to the field
+func uuidFieldValue(field *schema.Field, b []byte) interface{} {
+	if field.IndirectFieldType.Kind() == reflect.String {
+		return formatUUID(b)
+	}
+	if isUUIDType(field.IndirectFieldType) {
+		array := reflect.New(field.IndirectFieldType).Elem()
+		reflect.Copy(array, reflect.ValueOf(b))
+		return array.Interface()
+	}
+	return b
+}
+
+// encodeUUIDVar maps UUID parameters to their stored BLOB value
+func encodeUUIDVar(v interface{}) (interface{}, bool) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return v, false
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return v, false
+	}
+	if isUUIDType(rv.Type()) {
+		b := make([]byte, uuidSize)
+		reflect.Copy(reflect.ValueOf(b), rv)
+		return b, true
+	}
+
+	dataTyper, ok := v.(schema.GormDataTypeInterface)
+	if !ok || schema.DataType(dataTyper.GormDataType()) != UUIDDataType {
+		return v, false
+	}
+	value := v
+	if valuer, ok := v.(driver.Valuer); ok {
+		var err error
+		if value, err = valuer.Value(); err != nil {
+			return v, false
+		}
+	}
+	if s, ok := value.(string); ok {
+		if b, err := parseUUID(s); err == nil {
+			return b, true
+		}
+	}
+	return value, true
+}
+
+// uuidColumn scans the BLOB value of a UUID column into a 16 byte array
+type uuidColumn [uuidSize]byte
+
+func (u *uuidColumn) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		if len(v) != uuidSize {
+			return fmt.Errorf("invalid UUID of %d bytes", len(v))
+		}
+		copy(u[:], v)
+	case string:
+		b, err := parseUUID(v)
+		if err != nil {
+			return err
+		}
+		copy(u[:], b)
+	case nil:
+		*u = uuidColumn{}
+	default:
+		return fmt.Errorf("unsupported value %v of type %T for a UUID column", src, src)
+	}
+	return nil
+}
+
+// uuidStringColumn scans the BLOB value of a UUID column into its canonical form
+type uuidStringColumn string
+
+func (u *uuidStringColumn) Scan(src interface{}) error {
+	var column uuidColumn
+	if err := column.Scan(src); err != nil {
+		return err
+	}
+	if src == nil {
+		*u = ""
+	} else {
+		*u = uuidStringColumn(formatUUID(column[:]))
+	}
+	return nil
+}
+
+var (
+	uuidColumnPool = &sync.Pool{
+		New: func() interface{} {
+			return new(*uuidColumn)
+		},
+	}
+	uuidStringColumnPool = &sync.Pool{
+		New: func() interface{} {
+			return new(*uuidStringColumn)
+		},
+	}
+)
+
+// prepareUUIDField makes gorm scan the UUID field through uuidColumn, unless the field type is a scanner
+func prepareUUIDField(field *schema.Field) {
+	if reflect.PtrTo(field.IndirectFieldType).Implements(scannerType) {
+		return
+	}
+	switch {
+	case isUUIDType(field.IndirectFieldType):
+		field.NewValuePool = uuidColumnPool
+	case field.IndirectFieldType.Kind() == reflect.String:
+		field.NewValuePool = uuidStringColumnPool
+	}
+}
+
+func hasUUIDDefault(field *schema.Field) bool {
+	return field.HasDefaultValue && uuidDefaults[strings.ToLower(strings.TrimSpace(field.DefaultValue))]
+}
+
+// generateUUIDs fills the zero UUID primary keys, and the zero UUID fields with a UUID function default, of the
+// created records with random UUIDs, as gorm leaves them to the database
+func (dialector *Dialector) generateUUIDs(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+
+	selectColumns, restricted := db.Statement.SelectAndOmitColumns(true, false)
+	for _, field := range db.Statement.Schema.Fields {
+		if !isUUIDField(field) || !(hasUUIDDefault(field) || (field.PrimaryKey && !field.HasDefaultValue)) {
+			continue
+		}
+		if v, ok := selectColumns[field.DBName]; (ok && !v) || (!ok && restricted) {
+			continue
+		}
+
+		generate := func(rv reflect.Value) {
+			if _, isZero := field.ValueOf(db.Statement.Context, rv); !isZero {
+				return
+			}
+			b, err := newUUID()
+			if db.AddError(err) == nil {
+				db.AddError(field.Set(db.Statement.Context, rv, uuidFieldValue(field, b)))
+			}
+		}
+		switch db.Statement.ReflectValue.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < db.Statement.ReflectValue.Len(); i++ {
+				if rv := reflect.Indirect(db.Statement.ReflectValue.Index(i)); rv.IsValid() {
+					generate(rv)
+				}
+			}
+		case reflect.Struct:
+			generate(db.Statement.ReflectValue)
+		}
+	}
+}
@@ -25,9 +25,14 @@ import (
 	"github.com/codenotary/immudb/pkg/client"
 	"gorm.io/gorm"
 	"strings"
+	"time"
 )
 
 func (dialector *Dialector) verify(db *gorm.DB) {
+	// rows of queries without a model have no known primary key, grouped queries are refused by groupBy
+	if _, grouped := db.Statement.Clauses["GROUP BY"]; db.Error != nil || db.Statement.Schema == nil || grouped {
+		return
+	}
 	rows, err := db.Rows()
 	if err != nil {
 		db.AddError(err)
@@ -35,7 +40,13 @@ func (dialector *Dialector) verify(db *gorm.DB) {
 	}
 	dbName := dialector.opts.Database
 	tableName := db.Statement.Table
-	pkeyName := db.Statement.Schema.PrioritizedPrimaryField.DBName
+	pkeyNames := make([]string, 0, len(db.Statement.Schema.PrimaryFields))
+	for _, field := range db.Statement.Schema.PrimaryFields {
+		pkeyNames = append(pkeyNames, quoteImmuCol(field.DBName, dbName, tableName))
+	}
+	if dialector.hasSurrogateKey(db.Statement.Schema) {
+		pkeyNames = append(pkeyNames, quoteImmuCol(SurrogateKeyColumn, dbName, tableName))
+	}
 
 	if from, ok := db.Statement.Clauses["FROM"]; ok {
 		if _, ok := from.AfterExpression.(TimeTravel); ok {
@@ -50,7 +61,7 @@ func (dialector *Dialector) verify(db *gorm.DB) {
 		return
 	}
 
-	pkey, err := getPrimaryKeyFromRow(quoteImmuCol(pkeyName, dbName, tableName), r)
+	pkey, err := getPrimaryKeyFromRow(pkeyNames, r)
 	if err != nil {
 		db.AddError(err)
 		return
@@ -70,14 +81,24 @@ func (dialector *Dialector) verify(db *gorm.DB) {
 	return
 }
 
-func getPrimaryKeyFromRow(pkeyName string, r *immuschema.Row) ([]*immuschema.SQLValue, error) {
-	for i, v := range r.Values {
-		if r.Columns[i] == pkeyName {
-			return []*immuschema.SQLValue{v}, nil
+func getPrimaryKeyFromRow(pkeyNames []string, r *immuschema.Row) ([]*immuschema.SQLValue, error) {
+	pkey := make([]*immuschema.SQLValue, 0, len(pkeyNames))
+	for _, pkeyName := range pkeyNames {
+		found := false
+		for i, v := range r.Values {
+			if r.Columns[i] == pkeyName {
+				pkey = append(pkey, v)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("primary key not found")
 		}
 	}
-	return nil, errors.New("primary key not found")
+	return pkey, nil
 }
+
 func getImmuRowFromSQLRow(dbName, tableName string, rows *sql.Rows) (*immuschema.Row, error) {
 	cols, err := rows.Columns()
 	if err != nil {
@@ -105,7 +126,8 @@ func getImmuRowFromSQLRow(dbName, tableName string, rows *sql.Rows) (*immuschema
 		case "BLOB":
 			vals[i] = new([]byte)
 		case "ANY":
-			vals[i] = new(sql.NullString)
+			// the type of columns holding NULL values is not known, it comes from the scanned value
+			vals[i] = new(interface{})
 		case "TIMESTAMP":
 			vals[i] = new(sql.NullTime)
 		default:
@@ -134,13 +156,27 @@ func getImmuRowFromSQLRow(dbName, tableName string, rows *sql.Rows) (*immuschema
 				s = &immuschema.SQLValue{Value: &immuschema.SQLValue_B{B: t.Bool}}
 			}
 		case *[]byte:
-			if len(*t) > 0 {
+			// NULL is scanned as a nil slice, an empty BLOB as an empty one
+			if *t != nil {
 				s = &immuschema.SQLValue{Value: &immuschema.SQLValue_Bs{Bs: *t}}
 			}
 		case *sql.NullTime:
 			if t.Valid {
 				s = &immuschema.SQLValue{Value: &immuschema.SQLValue_Ts{Ts: embsql.TimeToInt64(t.Time)}}
 			}
+		case *interface{}:
+			switch v := (*t).(type) {
+			case string:
+				s = &immuschema.SQLValue{Value: &immuschema.SQLValue_S{S: v}}
+			case int64:
+				s = &immuschema.SQLValue{Value: &immuschema.SQLValue_N{N: v}}
+			case bool:
+				s = &immuschema.SQLValue{Value: &immuschema.SQLValue_B{B: v}}
+			case []byte:
+				s = &immuschema.SQLValue{Value: &immuschema.SQLValue_Bs{Bs: v}}
+			case time.Time:
+				s = &immuschema.SQLValue{Value: &immuschema.SQLValue_Ts{Ts: embsql.TimeToInt64(v)}}
+			}
 		default:
 			s = nil
 		}
@@ -0,0 +1,240 @@
+/*
+Copyright 2021 CodeNotary, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package immudb
+
+import (
+	"database/sql/driver"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"strings"
+)
+
+// buildWhere rewrites the conditions of a WHERE clause into forms accepted by immudb before building it.
+func buildWhere(c clause.Clause, builder clause.Builder) {
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		c.Build(builder)
+		return
+	}
+
+	stmt, _ := builder.(*gorm.Statement)
+	exprs := make([]clause.Expression, len(where.Exprs))
+	for idx, expr := range where.Exprs {
+		if in, ok := expr.(clause.IN); ok && stmt != nil {
+			expr = rewriteUUIDPrimaryKeyIN(stmt, in)
+		}
+		if stmt != nil && stmt.Schema != nil {
+			if dialector, ok := stmt.DB.Dialector.(*Dialector); ok {
+				expr = dialector.rewriteEncryptedConditions(stmt.Schema, expr)
+			}
+		}
+		exprs[idx] = rewriteWhereExpr(expr)
+	}
+	c.Expression = clause.Where{Exprs: exprs}
+	c.Build(builder)
+}
+
+// buildGroupBy rewrites the HAVING conditions like the WHERE ones.
+func buildGroupBy(c clause.Clause, builder clause.Builder) {
+	if groupBy, ok := c.Expression.(clause.GroupBy); ok && len(groupBy.Having) > 0 {
+		groupBy.Having = rewriteWhereExprs(groupBy.Having)
+		c.Expression = groupBy
+	}
+	c.Build(builder)
+}
+
+func rewriteWhereExpr(expr clause.Expression) clause.Expression {
+	switch v := expr.(type) {
+	case clause.AndConditions:
+		return clause.AndConditions{Exprs: rewriteWhereExprs(v.Exprs)}
+	case clause.OrConditions:
+		return clause.OrConditions{Exprs: rewriteWhereExprs(v.Exprs)}
+	case clause.NotConditions:
+		// the negated conditions are all true
+		negated := make([]clause.Expression, len(v.Exprs))
+		for idx, e := range v.Exprs {
+			negated[idx] = negateWhereExpr(e)
+		}
+		return clause.AndConditions{Exprs: negated}
+	case clause.IN:
+		if columns, ok := v.Column.([]clause.Column); ok {
+			return rewriteCompositeIN(columns, v.Values)
+		}
+	case clause.Neq:
+		return notEqual(v)
+	case clause.Expr:
+		v.SQL = rewriteNotEqualOperator(v.SQL)
+		return v
+	case clause.NamedExpr:
+		v.SQL = rewriteNotEqualOperator(v.SQL)
+		return v
+	}
+	return expr
+}
+
+// negateWhereExpr returns the negation of a condition. Comparisons are negated by their operator, other conditions
+// are wrapped in NOT (...), as gorm leaves the parentheses out of groups of a single condition.
+func negateWhereExpr(expr clause.Expression) clause.Expression {
+	switch v := expr.(type) {
+	case clause.Eq:
+		return notEqual(v)
+	case clause.Neq:
+		return clause.Eq(v)
+	case clause.Gt:
+		return clause.Lte(v)
+	case clause.Gte:
+		return clause.Lt(v)
+	case clause.Lt:
+		return clause.Gte(v)
+	case clause.Lte:
+		return clause.Gt(v)
+	case clause.IN:
+		if _, ok := v.Column.([]clause.Column); ok {
+			return notCondition{rewriteWhereExpr(v)}
+		}
+		if len(v.Values) == 1 {
+			if _, ok := v.Values[0].([]interface{}); !ok {
+				return notEqual{Column: v.Column, Value: v.Values[0]}
+			}
+		}
+		return clause.NotConditions{Exprs: []clause.Expression{v}}
+	case clause.NegationExpressionBuilder:
+		return clause.NotConditions{Exprs: []clause.Expression{expr}}
+	}
+	return notCondition{rewriteWhereExpr(expr)}
+}
+
+func rewriteWhereExprs(exprs []clause.Expression) []clause.Expression {
+	rewritten := make([]clause.Expression, len(exprs))
+	for idx, expr := range exprs {
+		rewritten[idx] = rewriteWhereExpr(expr)
+	}
+	return rewritten
+}
+
+// rewriteCompositeIN turns a tuple condition like (a, b) IN ((1, 2), (3, 4)), produced by gorm for composite
+// primary and foreign keys, into (a = 1 AND b = 2) OR (a = 3 AND b = 4), as immudb has no row value expressions.
+func rewriteCompositeIN(columns []clause.Column, values []interface{}) clause.Expression {
+	if len(values) == 0 {
+		return clause.Expr{SQL: "FALSE"}
+	}
+
+	conds := make([]clause.Expression, 0, len(values))
+	for _, value := range values {
+		tuple, ok := value.([]interface{})
+		if !ok || len(tuple) != len(columns) {
+			return clause.IN{Column: columns, Values: values}
+		}
+
+		eqs := make([]clause.Expression, len(columns))
+		for idx, column := range columns {
+			eqs[idx] = clause.Eq{Column: column, Value: tuple[idx]}
+		}
+		conds = append(conds, clause.And(eqs...))
+	}
+	return clause.Or(conds...)
+}
+
+// rewriteUUIDPrimaryKeyIN turns the condition gorm produces for a 16 byte array primary key value, like in
+// db.First(&user, id), back into an equality. gorm takes the array for a list of primary keys.
+func rewriteUUIDPrimaryKeyIN(stmt *gorm.Statement, in clause.IN) clause.Expression {
+	if in.Column != clause.PrimaryColumn || len(in.Values) != uuidSize || stmt.Schema == nil {
+		return in
+	}
+	field := stmt.Schema.PrioritizedPrimaryField
+	if field == nil || !isUUIDType(field.IndirectFieldType) {
+		return in
+	}
+
+	array := reflect.New(field.IndirectFieldType).Elem()
+	for idx, value := range in.Values {
+		b, ok := value.(uint8)
+		if !ok {
+			return in
+		}
+		array.Index(idx).SetUint(uint64(b))
+	}
+	return clause.Eq{Column: clause.PrimaryColumn, Value: array.Interface()}
+}
+
+// notEqual is a clause.Neq written with !=, immudb has no <> operator
+type notEqual clause.Neq
+
+func (neq notEqual) Build(builder clause.Builder) {
+	switch neq.Value.(type) {
+	case []string, []int, []int32, []int64, []uint, []uint32, []uint64, []interface{}:
+		clause.Neq(neq).Build(builder)
+		return
+	}
+	if isNilValue(neq.Value) {
+		clause.Neq(neq).Build(builder)
+		return
+	}
+	builder.WriteQuoted(neq.Column)
+	builder.WriteString(" != ")
+	builder.AddVar(builder, neq.Value)
+}
+
+func isNilValue(value interface{}) bool {
+	if valuer, ok := value.(driver.Valuer); ok {
+		if rv := reflect.ValueOf(valuer); rv.Kind() != reflect.Ptr || !rv.IsNil() {
+			value, _ = valuer.Value()
+		}
+	}
+	rv := reflect.ValueOf(value)
+	return value == nil || (rv.Kind() == reflect.Ptr && rv.IsNil())
+}
+
+// notCondition is the negation of a condition, wrapped in parentheses
+type notCondition struct {
+	expr clause.Expression
+}
+
+func (not notCondition) Build(builder clause.Builder) {
+	builder.WriteString("NOT (")
+	not.expr.Build(builder)
+	builder.WriteByte(')')
+}
+
+// rewriteNotEqualOperator replaces the <> operators of a SQL condition with !=, leaving quoted text as it is
+func rewriteNotEqualOperator(sql string) string {
+	if !strings.Contains(sql, "<>") {
+		return sql
+	}
+
+	var (
+		rewritten strings.Builder
+		quote     byte
+	)
+	for i := 0; i < len(sql); i++ {
+		switch {
+		case quote != 0:
+			if sql[i] == quote {
+				quote = 0
+			}
+		case sql[i] == '\'' || sql[i] == '"' || sql[i] == '`':
+			quote = sql[i]
+		case sql[i] == '<' && i+1 < len(sql) && sql[i+1] == '>':
+			rewritten.WriteString("!=")
+			i++
+			continue
+		}
+		rewritten.WriteByte(sql[i])
+	}
+	return rewritten.String()
+}